@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/fakesite"
+)
+
+var titles = []string{"Fahrrad", "iPhone 12", "iPhone Hülle", "Sofa", "RTX 3080", "Kinderwagen", "Gartenstuhl"}
+
+var locations = []string{"50667 Köln", "10115 Berlin", "44787 Bochum"}
+
+// runs the fake kleinanzeigen site so the bot can be started with -base-url against it
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8081", "address the fake site listens on")
+	every := flag.Duration("every", time.Second*30, "interval in which a new ad is published")
+
+	flag.Parse()
+
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	listener, err := net.Listen("tcp", *addr)
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not listen")
+	}
+
+	site := fakesite.NewUnstartedServer()
+	site.Listener.Close()
+	site.Listener = listener
+	site.Start()
+	defer site.Close()
+
+	log.Info().Str("base_url", site.BaseURL()).Msg("fake site started")
+
+	id := 1000000000
+
+	for {
+		id++
+		title := titles[rand.Intn(len(titles))]
+
+		site.AddAd(fakesite.Ad{
+			ID:          fmt.Sprint(id),
+			Title:       title,
+			Price:       fmt.Sprintf("%d € VB", rand.Intn(500)+1),
+			Location:    locations[rand.Intn(len(locations))],
+			Description: fmt.Sprintf("Verkaufe %s in gutem Zustand.", title),
+			SellerName:  "Max",
+		})
+
+		log.Info().Int("id", id).Str("title", title).Msg("published ad")
+
+		time.Sleep(*every)
+	}
+}
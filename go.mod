@@ -3,16 +3,10 @@ module github.com/danielstefank/kleinanzeigen-alert
 go 1.16
 
 require (
-	github.com/antchfx/htmlquery v1.2.3 // indirect
-	github.com/antchfx/xmlquery v1.3.6 // indirect
+	github.com/PuerkitoBio/goquery v1.5.1
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
-	github.com/gobwas/glob v0.2.3 // indirect
-	github.com/gocolly/colly v1.2.0
 	github.com/jinzhu/gorm v1.9.16
-	github.com/kennygrant/sanitize v1.2.4 // indirect
 	github.com/rs/zerolog v1.23.0
-	github.com/saintfish/chardet v0.0.0-20120816061221-3af4cd4741ca // indirect
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
-	github.com/temoto/robotstxt v1.1.2 // indirect
-	google.golang.org/appengine v1.6.7 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -1,111 +1,360 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"html"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/alert"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/config"
 	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scheduler"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
 
 	"github.com/danielstefank/kleinanzeigen-alert/pkg/storage"
 	"github.com/danielstefank/kleinanzeigen-alert/pkg/telegram"
 )
 
-var token string
-
 var f = fmt.Sprintf
 
-const fetchDuration = time.Second * 60
+// the polling is paused after this many blocked requests in a row
+const blockThreshold = 3
+
+const blockMinBackoff = time.Minute * 5
+
+const blockMaxBackoff = time.Hour * 6
+
+// the admin is reminded of a changed layout at most once in this interval
+const layoutAlertInterval = time.Hour
 
 func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	debug := flag.Bool("debug", false, "sets log level to debug")
 
-	flag.Parse()
+	cfg, args, err := config.Load(os.Args[1:])
+
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	// Default level for this example is info, unless debug flag is present
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	if *debug {
+	if cfg.Debug {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}).With().Caller().Logger().With().Timestamp().Logger()
 	} else {
 		log.Logger = log.With().Caller().Logger().With().Timestamp().Logger()
 	}
 
-	token = os.Getenv("TELEGRAM_APITOKEN")
+	switch strings.Join(args, " ") {
+	case "":
+	case "config check":
+		checkConfig(cfg)
+		return
+	case "migrate status":
+		migrationStatus(cfg)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q. available: config check, migrate status\n", strings.Join(args, " "))
+		os.Exit(2)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Panic().Err(err).Msg("could not start")
+	}
+
+	run(cfg)
+}
+
+// checkConfig prints the effective configuration and exits with 1 if it is invalid
+func checkConfig(cfg *config.Config) {
+	fmt.Print(cfg.String())
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintln(os.Stderr, "configuration is valid")
+}
+
+// migrationStatus prints the applied and pending migrations of the database. The migrations are applied when the bot starts
+func migrationStatus(cfg *config.Config) {
+	states, err := storage.MigrationStatus(cfg.Database.Path)
 
-	if token == "" {
-		log.Panic().Msg("could read API token")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not read migrations of %s: %v\n", cfg.Database.Path, err)
 		os.Exit(1)
 	}
 
-	s := storage.NewStorage()
-	defer s.CloseDB()
-	bot := telegram.CreateBot(token, s)
+	pending := 0
+
+	for _, state := range states {
+		applied := "pending"
+
+		if state.AppliedAt != nil {
+			applied = "applied " + state.AppliedAt.Format(time.RFC3339)
+		} else {
+			pending++
+		}
+
+		fmt.Printf("%04d %-30s %s\n", state.Version, state.Name, applied)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d of %d migrations pending\n", pending, len(states))
+}
+
+func run(cfg *config.Config) {
+	adminChatID := cfg.Telegram.AdminChatID
+
+	client := scraper.NewClient(cfg.Scraper.Rate, cfg.Scraper.Burst, cfg.Scraper.Concurrency, cfg.Scraper.Timeout, cfg.Scraper.UserAgent)
+
+	if len(cfg.Scraper.Proxies) > 0 {
+		if err := client.UseProxies(cfg.Scraper.Proxies, cfg.Scraper.ProxyCooldown); err != nil {
+			log.Panic().Err(err).Msg("could not use proxies")
+		}
+	}
+
+	scraper.SetClient(client)
+
+	if cfg.Scraper.BaseURL != scraper.DefaultBaseURL {
+		log.Info().Str("base_url", cfg.Scraper.BaseURL).Msg("using custom base url for kleinanzeigen")
+	}
+
+	kleinanzeigen := scraper.NewKleinanzeigen(cfg.Scraper.BaseURL)
+	kleinanzeigen.SetCityLookup(cfg.Scraper.CityUserAgent, cfg.Scraper.CityTimeout)
+	scraper.Register(kleinanzeigen)
+
+	if cfg.Scraper.CoalesceTTL > 0 {
+		scraper.CoalesceAll(cfg.Scraper.CoalesceTTL)
+	}
+
+	scraper.SetDumpDir(cfg.Scraper.LayoutDumpDir)
+
+	if selectors := cfg.Scraper.Selectors; selectors != "" {
+		if err := scraper.LoadSelectors(selectors); err != nil {
+			log.Panic().Err(err).Str("path", selectors).Msg("could not load selectors")
+		}
+
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+
+		go func() {
+			for range reload {
+				// a broken file keeps the selectors that are in use
+				if err := scraper.LoadSelectors(selectors); err != nil {
+					log.Error().Err(err).Str("path", selectors).Msg("could not reload selectors")
+				}
+			}
+		}()
+	}
+
+	// ctx is done on SIGINT or SIGTERM. The running fetches get fetchCtx which is only cancelled if they do not finish in time
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fetchCtx, abortFetches := context.WithCancel(context.Background())
+	defer abortFetches()
+
+	var s storage.Store = storage.NewSQLite(cfg.Database.Path)
+	alerts := alert.NewService(s)
+	bot := telegram.CreateBot(cfg.Telegram.Token, s, alerts)
+	bot.SetAdminChatID(adminChatID)
+	bot.SetCommandTimeout(cfg.Telegram.CommandTimeout)
 	bot.Init()
-	go bot.Start()
 
-	cleanupTicker := time.NewTicker(time.Hour)
+	botDone := make(chan struct{})
+
+	go func() {
+		bot.Start(ctx)
+		close(botDone)
+	}()
+
+	health := scraper.NewHealth(blockThreshold, blockMinBackoff, blockMaxBackoff)
+	health.OnChange(func(blocked bool, until time.Time) {
+		if adminChatID == 0 {
+			return
+		}
+
+		if blocked {
+			bot.SendMsg(adminChatID, f("Kleinanzeigen blockiert den Bot. Alle Suchen pausieren bis %s.", until.Format("02.01.2006 15:04:05")))
+		} else {
+			bot.SendMsg(adminChatID, "Kleinanzeigen ist wieder erreichbar. Die Suchen laufen weiter.")
+		}
+	})
+
+	var layoutMu sync.Mutex
+	var lastLayoutAlert time.Time
+
+	alertLayout := func(err error) {
+		layoutMu.Lock()
+		defer layoutMu.Unlock()
+
+		if adminChatID == 0 || time.Since(lastLayoutAlert) < layoutAlertInterval {
+			return
+		}
+
+		lastLayoutAlert = time.Now()
+		bot.SendMsg(adminChatID, f("Das Layout von Kleinanzeigen hat sich vermutlich geändert. Es werden keine Anzeigen mehr erkannt: %s", html.EscapeString(err.Error())))
+	}
+
+	cleanupTicker := time.NewTicker(cfg.Database.CleanupInterval)
 
 	go func() {
 		for {
-			<-cleanupTicker.C
+			select {
+			case <-cleanupTicker.C:
+			case <-ctx.Done():
+				cleanupTicker.Stop()
+				return
+			}
+
 			log.Info().Msg("Removing old ads.")
-			deleted, err := s.DeleteOlderAds()
+			deleted, err := s.DeleteOlderAds(ctx, cfg.Database.Retention)
 
 			if err != nil {
 				log.Error().Err(err).Msg("could not delete old ads")
 				return
 			}
 
-			log.Info().Int64("affected_ads", deleted).Msg("Old ads removed. Sleeping for 1 hour.")
+			log.Info().Int64("affected_ads", deleted).Dur("next_cleanup_in", cfg.Database.CleanupInterval).Msg("Old ads removed.")
 		}
 	}()
 
-	for {
-		queries := s.GetQueries()
-
-		log.Info().Int("number_of_queries", len(queries)).Msg("fetching ads")
-		for _, q := range queries {
-			go func(query model.Query) {
-				new, err := s.GetLatest(query.ID)
-
-				if err != nil {
-					if query.FailedPreviously {
-						s.RemoveByID(query.ID, query.ChatID)
-						bot.SendMsg(query.ChatID, f("Anzeigen für %s (ID: %d) konnten nicht geladen werden. Das Problem ist erneut aufgetreten. Die Query wurde gelöscht.", query.Term, query.ID))
-					} else {
-						bot.SendMsg(query.ChatID, f("Anzeigen konnten für %s (ID: %d) nicht geladen werden. Falls das Problem weiterhin besteht, wird die Query gelöscht. Der Bot könnte überlastet sein, oder die Query enthält Fehler.", query.Term, query.ID))
-						query.FailedPreviously = true
-						s.UpdateQuery(query.ID, true)
-					}
-					return
-				}
+	process := func(ctx context.Context, query model.Query) {
+		new, err := alerts.GetLatest(ctx, query.ID, cfg.Scheduler.MaxPages)
+		health.Report(err)
 
-				if query.FailedPreviously {
-					s.UpdateQuery(query.ID, false)
-				}
+		switch {
+		case errors.Is(err, context.Canceled):
+			// the bot is shutting down
+			return
+		case errors.Is(err, scraper.ErrLayoutChanged):
+			// the parser is broken for all queries. this is not a problem of the query
+			alertLayout(err)
+			return
+		case scraper.SiteWide(err):
+			// the whole site is blocked. this is not a problem of the query either
+			return
+		case errors.Is(err, scraper.ErrTimeout):
+			// the site is slow for all queries. the query is fetched again with the next run
+			log.Warn().Err(err).Uint("query_id", query.ID).Msg("fetching the query timed out")
+			return
+		case err != nil:
+			// a link that does not exist anymore may only be missing for a moment, so it is quarantined after
+			// model.QuarantineAfter failures like any other failure of the query
+			failed := s.RecordFailure(ctx, query.ID, err)
 
-				log.Debug().Int("number_of_new_ads", len(new)).Msg("new ads found")
-				err = bot.SendAds(query.ChatID, new, query)
-				if err != nil {
-					affected, err := s.RemoveByChatID(query.ChatID)
-					if err != nil {
-						log.Error().Err(err).
-							Msg("could not remove  queries for blocked/deactivated user")
-					} else {
-						log.Info().
-							Int("number_of_removed_queries", affected).
-							Msg("removed queries for blocked/deactivated user")
-					}
-				}
-			}(q)
+			if failed == nil {
+				// the query was removed while it was fetched
+				return
+			} else if failed.Quarantined {
+				bot.SendQuarantined(query.ChatID, *failed)
+			} else if failed.FailureCount == 1 {
+				bot.SendMsg(query.ChatID, f("Anzeigen konnten für %s (ID: %d) nicht geladen werden: %s Es wird später erneut versucht. Falls das Problem weiterhin besteht, wird die Suche pausiert. Details mit /status.", html.EscapeString(query.Term), query.ID, telegram.ErrorText(err)))
+			}
+			return
 		}
 
-		time.Sleep(fetchDuration)
+		if query.FailureCount > 0 {
+			s.RecordSuccess(ctx, query.ID)
+		}
+
+		log.Debug().Int("number_of_new_ads", len(new)).Msg("new ads found")
+		err = bot.SendAds(query.ChatID, new, query)
+		if err != nil {
+			affected, err := s.RemoveByChatID(ctx, query.ChatID)
+			if err != nil {
+				log.Error().Err(err).
+					Msg("could not remove  queries for blocked/deactivated user")
+			} else {
+				log.Info().
+					Int("number_of_removed_queries", affected).
+					Msg("removed queries for blocked/deactivated user")
+			}
+		}
+	}
+
+	queries := func() []model.Query {
+		return s.GetQueries(fetchCtx)
 	}
+
+	sched := scheduler.NewScheduler(cfg.Scheduler.Workers, cfg.Scheduler.Interval, cfg.Scheduler.Jitter, queries, process)
+	if cfg.Scheduler.Adaptive {
+		countAds := func(queryID uint, since time.Time) int {
+			return s.CountAdsSince(fetchCtx, queryID, since)
+		}
+
+		sched.SetAdaptive(scheduler.NewAdaptive(cfg.Scheduler.AdaptiveMin, cfg.Scheduler.AdaptiveMax, cfg.Database.Retention, countAds))
+	}
+
+	sched.SetTimeout(cfg.Scheduler.FetchTimeout)
+
+	location, err := time.LoadLocation(cfg.Scheduler.TimeZone)
+
+	if err != nil {
+		log.Panic().Err(err).Str("time_zone", cfg.Scheduler.TimeZone).Msg("could not load the time zone")
+	}
+
+	sched.SetLocation(location)
+	sched.SetGate(func() bool {
+		if !health.Allow() {
+			_, until := health.Blocked()
+			log.Info().Time("paused_until", until).Msg("site blocks the bot. skipping fetch")
+			return false
+		}
+
+		return true
+	})
+
+	schedDone := make(chan struct{})
+
+	go func() {
+		sched.Run(fetchCtx)
+		close(schedDone)
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	log.Info().Dur("timeout", cfg.Scheduler.ShutdownTimeout).Msg("shutting down")
+	deadline := time.Now().Add(cfg.Scheduler.ShutdownTimeout)
+
+	sched.Stop()
+
+	select {
+	case <-schedDone:
+	case <-time.After(time.Until(deadline)):
+		log.Warn().Msg("running fetches did not finish in time. aborting them")
+		abortFetches()
+		<-schedDone
+	}
+
+	select {
+	case <-botDone:
+	case <-time.After(time.Until(deadline)):
+		log.Warn().Msg("telegram bot did not stop in time. the offset of the handled updates might not be saved")
+	}
+
+	if !bot.Wait(time.Until(deadline)) {
+		log.Warn().Msg("running commands did not finish in time. aborting them")
+	}
+
+	s.Close()
+	log.Info().Msg("shut down")
 }
@@ -0,0 +1,130 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/filter"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/storage"
+)
+
+// Service creates queries and fetches their new ads from the sources. The state is kept in the store
+type Service struct {
+	store storage.Store
+}
+
+// NewService creates a new service on top of the store
+func NewService(store storage.Store) *Service {
+	return &Service{store: store}
+}
+
+// AddQuery adds a new query for the given source. The city and the current ads are fetched with the context
+func (s *Service) AddQuery(ctx context.Context, sourceName string, term string, exclude []string, city string, radius int, price *int, minPrice *int, chatID int64) (*model.Query, error) {
+	source, err := scraper.GetSource(sourceName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cityID, cityName, err := source.FindCity(ctx, city)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find city: %w", err)
+	}
+
+	query := model.Query{ChatID: chatID, Source: source.Name(), Term: term, Radius: radius, City: cityID, CityName: cityName, MaxPrice: price, MinPrice: minPrice}
+	query.SetExcludeTerms(exclude)
+
+	return s.create(ctx, source, &query)
+}
+
+// AddQueryViaLink adds a new query for a custom link. The source is picked by the link
+func (s *Service) AddQueryViaLink(ctx context.Context, link string, price *int, minPrice *int, chatID int64) (*model.Query, error) {
+	if link == "" {
+		return nil, scraper.ErrInvalidLink
+	}
+
+	source, err := scraper.SourceForLink(link)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := model.Query{ChatID: chatID, Source: source.Name(), CustomLink: &link, MaxPrice: price, MinPrice: minPrice}
+
+	return s.create(ctx, source, &query)
+}
+
+// create stores the query and marks its current ads as seen
+func (s *Service) create(ctx context.Context, source scraper.Source, query *model.Query) (*model.Query, error) {
+	if err := s.store.CreateQuery(ctx, query); err != nil {
+		return nil, err
+	}
+
+	latestAds, err := source.GetAds(ctx, 1, query.Search())
+
+	if err != nil {
+		// without the current ads every ad would be sent as new with the first run. the query is removed even if the
+		// context is done
+		s.store.RemoveByID(context.Background(), query.ID, query.ChatID)
+		return nil, fmt.Errorf("could not get latest ads: %w", err)
+	}
+
+	if err := s.store.StoreAds(ctx, query.ID, latestAds); err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+// GetLatest fetches the latest ads from the source of the query with the context. All ads that were not seen for the query are returned
+// and stored. The result pages are scraped until an already stored ad is reached or maxPages pages have been scraped.
+func (s *Service) GetLatest(ctx context.Context, id uint, maxPages int) ([]scraper.Ad, error) {
+	q := s.store.FindQueryByID(ctx, id)
+
+	if q == nil {
+		// the query was removed since it was scheduled
+		return make([]scraper.Ad, 0, 0), nil
+	}
+
+	source, err := scraper.GetSource(q.Source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the known ads on the scraped pages are stored again so their snapshots are up to date and they are kept as long
+	// as they are listed
+	reached := make([]scraper.Ad, 0, 0)
+
+	known := func(ad scraper.Ad) bool {
+		if s.store.IsKnownAd(ctx, q.ID, ad.ID) {
+			reached = append(reached, ad)
+			return true
+		}
+
+		return false
+	}
+
+	latest, err := scraper.GetAdsUntil(ctx, source, maxPages, known, q.Search())
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get latest ads: %w", err)
+	}
+
+	diff := s.store.NewAds(ctx, q.ID, latest)
+	chain := filter.ForQuery(*q)
+
+	if chain.NeedsDetails() {
+		diff = scraper.FetchDetails(ctx, source, diff)
+	}
+
+	// filtered ads are stored as well so the paging stops at them
+	if err := s.store.StoreAds(ctx, q.ID, append(diff, reached...)); err != nil {
+		return nil, err
+	}
+
+	return chain.Apply(diff), nil
+}
@@ -0,0 +1,221 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/fakesite"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/storage"
+)
+
+func addAds(site *fakesite.Server, from int, count int, title string, price string) {
+	for i := from; i < from+count; i++ {
+		site.AddAd(fakesite.Ad{ID: fmt.Sprint(i), Title: fmt.Sprintf("%s %d", title, i), Price: price, Location: "50667 Köln"})
+	}
+}
+
+func ids(ads []scraper.Ad) []string {
+	result := make([]string, 0, len(ads))
+
+	for _, ad := range ads {
+		result = append(result, ad.ID)
+	}
+
+	return result
+}
+
+func assertIDs(t *testing.T, ads []scraper.Ad, want ...string) {
+	t.Helper()
+
+	got := ids(ads)
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("reported ads %v, want %v", got, want)
+	}
+}
+
+func TestGetLatestReportsOnlyNewAds(t *testing.T) {
+	site := fakesite.Start(t)
+	addAds(site, 100, 3, "Fahrrad", "50 €")
+
+	ctx := context.Background()
+	service := NewService(storage.NewMemory())
+
+	q, err := service.AddQuery(ctx, scraper.DefaultSource, "fahrrad", nil, "Köln", 10, nil, nil, 42)
+
+	if err != nil {
+		t.Fatalf("could not add query: %v", err)
+	}
+
+	if q.CityName != "Köln" || q.City != 945 {
+		t.Errorf("city = %d %s, want 945 Köln", q.City, q.CityName)
+	}
+
+	// the ads listed when the query was added are not reported
+	latest, err := service.GetLatest(ctx, q.ID, 5)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertIDs(t, latest)
+
+	site.AddAd(fakesite.Ad{ID: "200", Title: "Fahrrad neu", Price: "80 €", Location: "50667 Köln"})
+	site.AddAd(fakesite.Ad{ID: "201", Title: "Kinderwagen", Price: "80 €", Location: "50667 Köln"})
+
+	latest, err = service.GetLatest(ctx, q.ID, 5)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertIDs(t, latest, "200")
+
+	latest, err = service.GetLatest(ctx, q.ID, 5)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertIDs(t, latest)
+}
+
+func TestGetLatestPagesUntilKnownAd(t *testing.T) {
+	site := fakesite.Start(t)
+	addAds(site, 100, 2, "Fahrrad", "50 €")
+
+	ctx := context.Background()
+	service := NewService(storage.NewMemory())
+
+	q, err := service.AddQuery(ctx, scraper.DefaultSource, "fahrrad", nil, "Köln", 10, nil, nil, 42)
+
+	if err != nil {
+		t.Fatalf("could not add query: %v", err)
+	}
+
+	// more new ads than fit on a result page, e.g. after a downtime
+	addAds(site, 300, fakesite.PageSize+5, "Fahrrad", "50 €")
+
+	latest, err := service.GetLatest(ctx, q.ID, 5)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(latest) != fakesite.PageSize+5 {
+		t.Fatalf("reported %d ads, want %d", len(latest), fakesite.PageSize+5)
+	}
+
+	for _, ad := range latest {
+		if ad.ID == "100" || ad.ID == "101" {
+			t.Errorf("known ad %s was reported", ad.ID)
+		}
+	}
+}
+
+func TestGetLatestRefreshesKnownAds(t *testing.T) {
+	site := fakesite.Start(t)
+	addAds(site, 100, 3, "Fahrrad", "50 €")
+
+	ctx := context.Background()
+	store := storage.NewMemory()
+	service := NewService(store)
+
+	q, err := service.AddQuery(ctx, scraper.DefaultSource, "fahrrad", nil, "Köln", 10, nil, nil, 42)
+
+	if err != nil {
+		t.Fatalf("could not add query: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 5)
+	before := time.Now()
+	site.AddAd(fakesite.Ad{ID: "200", Title: "Fahrrad neu", Price: "80 €", Location: "50667 Köln"})
+
+	if _, err := service.GetLatest(ctx, q.ID, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// the known ads behind the one the paging stopped at are still listed
+	for _, ad := range store.ListAds(ctx, q.ID) {
+		if ad.LastSeenAt.Before(before) {
+			t.Errorf("ad %s was last seen at %s, want it refreshed", ad.EbayID, ad.LastSeenAt)
+		}
+	}
+}
+
+func TestGetLatestAppliesFilters(t *testing.T) {
+	site := fakesite.Start(t)
+	addAds(site, 100, 1, "Fahrrad", "50 €")
+
+	ctx := context.Background()
+	store := storage.NewMemory()
+	service := NewService(store)
+	max := 100
+
+	q, err := service.AddQuery(ctx, scraper.DefaultSource, "fahrrad", []string{"defekt"}, "Köln", 10, &max, nil, 42)
+
+	if err != nil {
+		t.Fatalf("could not add query: %v", err)
+	}
+
+	site.AddAd(fakesite.Ad{ID: "200", Title: "Fahrrad teuer", Price: "500 €", Location: "50667 Köln"})
+	site.AddAd(fakesite.Ad{ID: "201", Title: "Fahrrad defekt", Price: "20 €", Location: "50667 Köln"})
+	site.AddAd(fakesite.Ad{ID: "202", Title: "Fahrrad günstig", Price: "90 € VB", Location: "50667 Köln"})
+
+	latest, err := service.GetLatest(ctx, q.ID, 5)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertIDs(t, latest, "202")
+
+	// filtered ads are stored so they are not checked again
+	for _, id := range []string{"200", "201", "202"} {
+		if !store.IsKnownAd(ctx, q.ID, id) {
+			t.Errorf("ad %s is not stored", id)
+		}
+	}
+}
+
+func TestAddQueryViaLink(t *testing.T) {
+	site := fakesite.Start(t)
+	addAds(site, 100, 2, "Fahrrad", "50 €")
+
+	ctx := context.Background()
+	service := NewService(storage.NewMemory())
+
+	if _, err := service.AddQueryViaLink(ctx, "https://example.com/s-fahrrad/k0", nil, nil, 42); !errors.Is(err, scraper.ErrInvalidLink) {
+		t.Errorf("foreign link: err = %v, want ErrInvalidLink", err)
+	}
+
+	q, err := service.AddQueryViaLink(ctx, site.BaseURL()+"s-fahrrad/k0", nil, nil, 42)
+
+	if err != nil {
+		t.Fatalf("could not add query: %v", err)
+	}
+
+	site.AddAd(fakesite.Ad{ID: "200", Title: "Fahrrad neu", Price: "80 €", Location: "50667 Köln"})
+
+	latest, err := service.GetLatest(ctx, q.ID, 5)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertIDs(t, latest, "200")
+}
+
+func TestAddQueryUnknownCity(t *testing.T) {
+	fakesite.Start(t)
+
+	service := NewService(storage.NewMemory())
+
+	if _, err := service.AddQuery(context.Background(), scraper.DefaultSource, "fahrrad", nil, "Hamburg", 10, nil, nil, 42); err == nil {
+		t.Error("query with an unknown city was added")
+	}
+}
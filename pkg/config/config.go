@@ -0,0 +1,372 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scheduler"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+)
+
+// Config is the configuration of the bot. The values are read from the defaults, the yaml file, the environment and
+// the flags. A later source overrides an earlier one.
+type Config struct {
+	Debug     bool      `yaml:"debug" env:"DEBUG"`
+	Telegram  Telegram  `yaml:"telegram"`
+	Database  Database  `yaml:"database"`
+	Scheduler Scheduler `yaml:"scheduler"`
+	Scraper   Scraper   `yaml:"scraper"`
+}
+
+// Telegram configures the bot
+type Telegram struct {
+	Token          string        `yaml:"token" env:"TELEGRAM_APITOKEN"`
+	AdminChatID    int64         `yaml:"admin_chat_id" env:"ADMIN_CHAT_ID"`
+	CommandTimeout time.Duration `yaml:"command_timeout" env:"TELEGRAM_COMMAND_TIMEOUT"`
+}
+
+// Database configures the storage
+type Database struct {
+	Path            string        `yaml:"path" env:"DATABASE_PATH"`
+	Retention       time.Duration `yaml:"retention" env:"DATABASE_RETENTION"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval" env:"DATABASE_CLEANUP_INTERVAL"`
+}
+
+// Scheduler configures when the queries are fetched
+type Scheduler struct {
+	Interval        time.Duration `yaml:"interval" env:"FETCH_INTERVAL"`
+	Workers         int           `yaml:"workers" env:"FETCH_WORKERS"`
+	Jitter          time.Duration `yaml:"jitter" env:"FETCH_JITTER"`
+	FetchTimeout    time.Duration `yaml:"fetch_timeout" env:"FETCH_TIMEOUT"`
+	MaxPages        int           `yaml:"max_pages" env:"FETCH_MAX_PAGES"`
+	Adaptive        bool          `yaml:"adaptive" env:"FETCH_ADAPTIVE"`
+	AdaptiveMin     time.Duration `yaml:"adaptive_min" env:"FETCH_ADAPTIVE_MIN"`
+	AdaptiveMax     time.Duration `yaml:"adaptive_max" env:"FETCH_ADAPTIVE_MAX"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	TimeZone        string        `yaml:"time_zone" env:"FETCH_TIME_ZONE"`
+}
+
+// Scraper configures the requests to the scraped sites
+type Scraper struct {
+	BaseURL       string        `yaml:"base_url" env:"SCRAPER_BASE_URL"`
+	UserAgent     string        `yaml:"user_agent" env:"SCRAPER_USER_AGENT"`
+	CityUserAgent string        `yaml:"city_user_agent" env:"SCRAPER_CITY_USER_AGENT"`
+	Timeout       time.Duration `yaml:"timeout" env:"SCRAPER_TIMEOUT"`
+	CityTimeout   time.Duration `yaml:"city_timeout" env:"SCRAPER_CITY_TIMEOUT"`
+	Rate          float64       `yaml:"rate" env:"SCRAPER_RATE"`
+	Burst         int           `yaml:"burst" env:"SCRAPER_BURST"`
+	Concurrency   int           `yaml:"concurrency" env:"SCRAPER_CONCURRENCY"`
+	Proxies       []string      `yaml:"proxies" env:"SCRAPER_PROXIES"`
+	ProxyCooldown time.Duration `yaml:"proxy_cooldown" env:"SCRAPER_PROXY_COOLDOWN"`
+	CoalesceTTL   time.Duration `yaml:"coalesce_ttl" env:"SCRAPER_COALESCE_TTL"`
+	Selectors     string        `yaml:"selectors" env:"SCRAPER_SELECTORS"`
+	LayoutDumpDir string        `yaml:"layout_dump_dir" env:"SCRAPER_LAYOUT_DUMP_DIR"`
+}
+
+// Default returns the configuration used if nothing is configured
+func Default() Config {
+	return Config{
+		Telegram: Telegram{
+			CommandTimeout: time.Minute,
+		},
+		Database: Database{
+			Path:            "/tmp/alert.db",
+			Retention:       time.Hour * 24 * 7,
+			CleanupInterval: time.Hour,
+		},
+		Scheduler: Scheduler{
+			Interval:        time.Minute,
+			Workers:         4,
+			Jitter:          time.Second * 45,
+			FetchTimeout:    time.Minute * 2,
+			MaxPages:        5,
+			Adaptive:        true,
+			AdaptiveMax:     time.Minute * 30,
+			ShutdownTimeout: time.Second * 8,
+			TimeZone:        scheduler.DefaultTimeZone,
+		},
+		Scraper: Scraper{
+			BaseURL:       scraper.DefaultBaseURL,
+			UserAgent:     "telegram-alert-bot/1.0",
+			CityUserAgent: scraper.DefaultCityUserAgent,
+			Timeout:       time.Second * 10,
+			CityTimeout:   time.Second * 2,
+			Rate:          1,
+			Burst:         5,
+			Concurrency:   4,
+			ProxyCooldown: time.Minute * 10,
+			CoalesceTTL:   time.Second * 50,
+			LayoutDumpDir: filepath.Join(os.TempDir(), "kleinanzeigen-alert"),
+		},
+	}
+}
+
+// Load reads the configuration. The file is given with -config or CONFIG_FILE. Only flags that are set override the
+// file and the environment. The arguments after the flags are returned, they hold the subcommand
+func Load(args []string) (*Config, []string, error) {
+	// the flags are parsed twice. first to find the file, then on top of the file and the environment
+	path := os.Getenv("CONFIG_FILE")
+	defaults := Default()
+	pre := flagSet(&defaults, &path)
+	pre.SetOutput(ioutil.Discard)
+
+	if err := pre.Parse(args); err != nil {
+		// parsed again to print the error with the usage
+		return nil, nil, flagSet(&defaults, &path).Parse(args)
+	}
+
+	c := Default()
+
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(&c).Elem()); err != nil {
+		return nil, nil, err
+	}
+
+	fs := flagSet(&c, &path)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	// the learned interval is not shorter than the interval of the scheduler unless configured otherwise
+	if c.Scheduler.AdaptiveMin == 0 {
+		c.Scheduler.AdaptiveMin = c.Scheduler.Interval
+	}
+
+	return &c, fs.Args(), nil
+}
+
+func (c *Config) readFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// flagSet binds the flags to the configuration. The current values are the defaults of the flags
+func flagSet(c *Config, path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	fs.StringVar(path, "config", *path, "yaml file with the configuration. defaults to CONFIG_FILE")
+	fs.BoolVar(&c.Debug, "debug", c.Debug, "sets log level to debug")
+
+	fs.DurationVar(&c.Telegram.CommandTimeout, "command-timeout", c.Telegram.CommandTimeout, "deadline of telegram commands that fetch from the scraped sites")
+
+	fs.StringVar(&c.Database.Path, "db-path", c.Database.Path, "path of the sqlite database")
+	fs.DurationVar(&c.Database.Retention, "retention", c.Database.Retention, "time an ad is kept after it was last seen")
+	fs.DurationVar(&c.Database.CleanupInterval, "cleanup-interval", c.Database.CleanupInterval, "interval in which old ads are removed")
+
+	fs.DurationVar(&c.Scheduler.Interval, "interval", c.Scheduler.Interval, "interval in which the due queries are fetched")
+	fs.IntVar(&c.Scheduler.Workers, "workers", c.Scheduler.Workers, "number of queries fetched at the same time")
+	fs.DurationVar(&c.Scheduler.Jitter, "jitter", c.Scheduler.Jitter, "maximum random delay to spread the fetches of a run")
+	fs.DurationVar(&c.Scheduler.FetchTimeout, "fetch-timeout", c.Scheduler.FetchTimeout, "deadline for fetching the pages and details of a single query. 0 disables it")
+	fs.IntVar(&c.Scheduler.MaxPages, "max-pages", c.Scheduler.MaxPages, "maximum number of result pages scraped per query and run")
+	fs.BoolVar(&c.Scheduler.Adaptive, "adaptive", c.Scheduler.Adaptive, "learn the polling interval of queries without an own interval from the frequency of new ads")
+	fs.DurationVar(&c.Scheduler.AdaptiveMin, "adaptive-min", c.Scheduler.AdaptiveMin, "shortest learned polling interval. defaults to the interval")
+	fs.DurationVar(&c.Scheduler.AdaptiveMax, "adaptive-max", c.Scheduler.AdaptiveMax, "longest learned polling interval")
+	fs.DurationVar(&c.Scheduler.ShutdownTimeout, "shutdown-timeout", c.Scheduler.ShutdownTimeout, "time the running fetches and commands get to finish on SIGINT or SIGTERM")
+	fs.StringVar(&c.Scheduler.TimeZone, "time-zone", c.Scheduler.TimeZone, "time zone the active hours of the queries are evaluated in")
+
+	fs.StringVar(&c.Scraper.BaseURL, "base-url", c.Scraper.BaseURL, "base url of kleinanzeigen. can point to the fake site of cmd/fakesite")
+	fs.StringVar(&c.Scraper.UserAgent, "user-agent", c.Scraper.UserAgent, "user agent of the requests to the scraped sites")
+	fs.StringVar(&c.Scraper.CityUserAgent, "city-user-agent", c.Scraper.CityUserAgent, "user agent of the city lookup")
+	fs.DurationVar(&c.Scraper.Timeout, "timeout", c.Scraper.Timeout, "timeout of a single request to the scraped sites")
+	fs.DurationVar(&c.Scraper.CityTimeout, "city-timeout", c.Scraper.CityTimeout, "timeout of the city lookup")
+	fs.Float64Var(&c.Scraper.Rate, "rate", c.Scraper.Rate, "requests per second and host sent to the scraped sites. 0 disables the limit")
+	fs.IntVar(&c.Scraper.Burst, "burst", c.Scraper.Burst, "number of requests that can be sent at once after a pause")
+	fs.IntVar(&c.Scraper.Concurrency, "concurrency", c.Scraper.Concurrency, "number of requests to the scraped sites running at the same time")
+	fs.Var((*listValue)(&c.Scraper.Proxies), "proxies", "comma separated list of http, https or socks5 proxies used for scraping")
+	fs.DurationVar(&c.Scraper.ProxyCooldown, "proxy-cooldown", c.Scraper.ProxyCooldown, "time a proxy is skipped after it was blocked or timed out")
+	fs.DurationVar(&c.Scraper.CoalesceTTL, "coalesce-ttl", c.Scraper.CoalesceTTL, "time a result page is shared between queries with the same search. 0 disables it")
+	fs.StringVar(&c.Scraper.Selectors, "selectors", c.Scraper.Selectors, "json file with css selectors replacing the built in ones. reloaded on SIGHUP")
+	fs.StringVar(&c.Scraper.LayoutDumpDir, "layout-dump-dir", c.Scraper.LayoutDumpDir, "directory result pages are saved to when the layout of the site changed")
+
+	return fs
+}
+
+// Validate checks the configuration and returns all problems at once
+func (c *Config) Validate() error {
+	problems := make([]string, 0, 0)
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	check(c.Telegram.Token != "", "telegram.token is missing. set TELEGRAM_APITOKEN")
+	check(c.Telegram.CommandTimeout > 0, "telegram.command_timeout has to be positive")
+
+	check(c.Database.Path != "", "database.path must not be empty")
+	check(c.Database.Retention > 0, "database.retention has to be positive")
+	check(c.Database.CleanupInterval > 0, "database.cleanup_interval has to be positive")
+
+	check(c.Scheduler.Interval >= time.Second, "scheduler.interval has to be at least 1s")
+	check(c.Scheduler.Workers >= 1, "scheduler.workers has to be at least 1")
+	check(c.Scheduler.Jitter >= 0, "scheduler.jitter must not be negative")
+	check(c.Scheduler.FetchTimeout >= 0, "scheduler.fetch_timeout must not be negative")
+	check(c.Scheduler.MaxPages >= 1, "scheduler.max_pages has to be at least 1")
+	check(c.Scheduler.AdaptiveMin >= c.Scheduler.Interval, "scheduler.adaptive_min has to be at least scheduler.interval")
+	check(c.Scheduler.AdaptiveMax >= c.Scheduler.AdaptiveMin, "scheduler.adaptive_max has to be at least scheduler.adaptive_min")
+	check(c.Scheduler.ShutdownTimeout >= 0, "scheduler.shutdown_timeout must not be negative")
+	_, err := time.LoadLocation(c.Scheduler.TimeZone)
+	check(c.Scheduler.TimeZone != "" && err == nil, "scheduler.time_zone has to be a time zone like Europe/Berlin")
+
+	check(strings.HasPrefix(c.Scraper.BaseURL, "http://") || strings.HasPrefix(c.Scraper.BaseURL, "https://"), "scraper.base_url has to be a http or https url")
+	check(c.Scraper.UserAgent != "", "scraper.user_agent must not be empty")
+	check(c.Scraper.Timeout > 0, "scraper.timeout has to be positive")
+	check(c.Scraper.CityTimeout > 0, "scraper.city_timeout has to be positive")
+	check(c.Scraper.Rate >= 0, "scraper.rate must not be negative")
+	check(c.Scraper.Burst >= 1, "scraper.burst has to be at least 1")
+	check(c.Scraper.Concurrency >= 1, "scraper.concurrency has to be at least 1")
+	check(c.Scraper.ProxyCooldown >= 0, "scraper.proxy_cooldown must not be negative")
+	check(c.Scraper.CoalesceTTL >= 0 && c.Scraper.CoalesceTTL < c.Scheduler.Interval, "scraper.coalesce_ttl has to be shorter than scheduler.interval")
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// String returns the configuration as yaml. The telegram token is redacted
+func (c Config) String() string {
+	if c.Telegram.Token != "" {
+		c.Telegram.Token = "<redacted>"
+	}
+
+	data, err := yaml.Marshal(c)
+
+	if err != nil {
+		return err.Error()
+	}
+
+	return string(data)
+}
+
+// applyEnv sets the fields with an env tag from the environment variables that are set
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		name := t.Field(i).Tag.Get("env")
+		value, ok := os.LookupEnv(name)
+
+		if name == "" || !ok {
+			continue
+		}
+
+		if err := setValue(field, value); err != nil {
+			return fmt.Errorf("invalid value of %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setValue(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetFloat(f)
+	case reflect.Slice:
+		field.Set(reflect.ValueOf(splitList(value)))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+
+	return nil
+}
+
+// listValue is a flag with a comma separated list
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = splitList(value)
+	return nil
+}
+
+func splitList(value string) []string {
+	list := make([]string, 0, 0)
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setEnv sets the environment variable for the test and restores it afterwards
+func setEnv(t *testing.T, key string, value string) {
+	t.Helper()
+
+	old, ok := os.LookupEnv(key)
+
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+
+	os.Setenv(key, value)
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+scheduler:
+  interval: 2m
+  workers: 8
+scraper:
+  timeout: 20s
+  rate: 3
+`)
+
+	setEnv(t, "CONFIG_FILE", path)
+	setEnv(t, "FETCH_WORKERS", "6")
+	setEnv(t, "SCRAPER_TIMEOUT", "30s")
+	setEnv(t, "SCRAPER_PROXIES", "http://a:8080, http://b:8080")
+
+	c, args, err := Load([]string{"-timeout", "40s", "-debug", "migrate", "status"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// defaults < file < environment < flags
+	if c.Scraper.Burst != 5 {
+		t.Errorf("burst = %d, want the default 5", c.Scraper.Burst)
+	}
+
+	if c.Scheduler.Interval != time.Minute*2 || c.Scraper.Rate != 3 {
+		t.Errorf("interval = %s, rate = %v, want 2m and 3 of the file", c.Scheduler.Interval, c.Scraper.Rate)
+	}
+
+	if c.Scheduler.Workers != 6 {
+		t.Errorf("workers = %d, want 6 of the environment", c.Scheduler.Workers)
+	}
+
+	if c.Scraper.Timeout != time.Second*40 || !c.Debug {
+		t.Errorf("timeout = %s, debug = %v, want 40s and true of the flags", c.Scraper.Timeout, c.Debug)
+	}
+
+	if want := []string{"http://a:8080", "http://b:8080"}; !reflect.DeepEqual(c.Scraper.Proxies, want) {
+		t.Errorf("proxies = %v, want %v", c.Scraper.Proxies, want)
+	}
+
+	// the learned interval defaults to the interval
+	if c.Scheduler.AdaptiveMin != time.Minute*2 {
+		t.Errorf("adaptive min = %s, want the interval", c.Scheduler.AdaptiveMin)
+	}
+
+	if want := []string{"migrate", "status"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestLoadConfigFlagOverridesEnvironment(t *testing.T) {
+	setEnv(t, "CONFIG_FILE", writeFile(t, "scheduler:\n  workers: 2\n"))
+	path := writeFile(t, "scheduler:\n  workers: 3\n")
+
+	c, _, err := Load([]string{"-config", path})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Scheduler.Workers != 3 {
+		t.Errorf("workers = %d, want 3 of the file given with -config", c.Scheduler.Workers)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Run("invalid environment", func(t *testing.T) {
+		setEnv(t, "FETCH_WORKERS", "many")
+
+		if _, _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "FETCH_WORKERS") {
+			t.Errorf("err = %v, want the invalid variable", err)
+		}
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		if _, _, err := Load([]string{"-config", writeFile(t, "scheduler: [")}); err == nil {
+			t.Error("invalid yaml was accepted")
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, _, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+			t.Error("missing file was accepted")
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		c := Default()
+		c.Telegram.Token = "token"
+		c.Scheduler.AdaptiveMin = c.Scheduler.Interval
+		return c
+	}
+
+	cases := []struct {
+		name     string
+		change   func(c *Config)
+		problems []string
+	}{
+		{"defaults with token", func(c *Config) {}, nil},
+		{"missing token", func(c *Config) { c.Telegram.Token = "" }, []string{"telegram.token"}},
+		{"short interval", func(c *Config) { c.Scheduler.Interval = time.Millisecond * 500 }, []string{"scheduler.interval"}},
+		{"adaptive bounds", func(c *Config) { c.Scheduler.AdaptiveMax = time.Second * 30 }, []string{"scheduler.adaptive_max"}},
+		{"time zone", func(c *Config) { c.Scheduler.TimeZone = "Mars/Olympus" }, []string{"scheduler.time_zone"}},
+		{"base url", func(c *Config) { c.Scraper.BaseURL = "www.kleinanzeigen.de" }, []string{"scraper.base_url"}},
+		{"coalesce ttl", func(c *Config) { c.Scraper.CoalesceTTL = c.Scheduler.Interval }, []string{"scraper.coalesce_ttl"}},
+		{"all problems at once", func(c *Config) {
+			c.Telegram.Token = ""
+			c.Scheduler.Workers = 0
+			c.Scraper.Rate = -1
+		}, []string{"telegram.token", "scheduler.workers", "scraper.rate"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := valid()
+			c.change(&config)
+			err := config.Validate()
+
+			if len(c.problems) == 0 {
+				if err != nil {
+					t.Errorf("err = %v, want valid", err)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("configuration is valid, want %v", c.problems)
+			}
+
+			for _, problem := range c.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("err = %v, want %s", err, problem)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package fakesite
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+var pages = template.Must(template.ParseFS(templates, "templates/*.html"))
+
+// PageSize is the number of ads on a result page like on kleinanzeigen.de
+const PageSize = 25
+
+var searchPath = regexp.MustCompile(`^/(?:seite:(\d+)/)?s-([^/?]+)(?:/([^/?]+))?/?$`)
+
+var adPath = regexp.MustCompile(`^/s-anzeige/[^/]+/(\d+)`)
+
+// Ad is a listing served by the fake site
+type Ad struct {
+	ID          string
+	Title       string
+	Price       string
+	Location    string
+	Description string
+	Shipping    string
+	SellerName  string
+	Commercial  bool
+	TopAd       bool
+	PostedAt    time.Time
+	Attributes  map[string]string
+}
+
+// Slug is the title part of the ad link
+func (a Ad) Slug() string {
+	return strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(a.Title), "-"), "-")
+}
+
+// Server is a fake kleinanzeigen.de serving result pages with paging, ad detail pages and the city lookup.
+// Use URL + "/" as base url of the kleinanzeigen source.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	ads      []Ad
+	cities   map[string]string
+	status   int
+	requests int
+}
+
+// NewServer starts a new fake site without ads
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+
+	return s
+}
+
+// NewUnstartedServer creates a new fake site without ads. The listener can be replaced before calling Start
+func NewUnstartedServer() *Server {
+	s := &Server{
+		cities: map[string]string{"_945": "Köln", "_3331": "Berlin"},
+		status: http.StatusOK,
+	}
+
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// BaseURL returns the base url of the fake site
+func (s *Server) BaseURL() string {
+	return s.URL + "/"
+}
+
+// AddAd publishes a new ad. New ads are shown first like on kleinanzeigen.de
+func (s *Server) AddAd(ad Ad) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ad.PostedAt.IsZero() {
+		ad.PostedAt = time.Now()
+	}
+
+	s.ads = append([]Ad{ad}, s.ads...)
+}
+
+// SetCities replaces the cities of the city lookup. The keys are the city ids prefixed with a single character
+func (s *Server) SetCities(cities map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cities = cities
+}
+
+// SetStatus makes the site answer every request with the given status, e.g. http.StatusForbidden to simulate a block
+func (s *Server) SetStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = status
+}
+
+// Requests returns the number of requests the site received
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	status := s.status
+	s.mu.Unlock()
+
+	log.Debug().Str("path", r.URL.Path).Msg("fake site request")
+
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/s-ort-empfehlungen.json":
+		s.handleCities(w, r)
+	case adPath.MatchString(r.URL.Path):
+		s.handleAd(w, adPath.FindStringSubmatch(r.URL.Path)[1])
+	case searchPath.MatchString(r.URL.Path):
+		match := searchPath.FindStringSubmatch(r.URL.Path)
+		s.handleSearch(w, match[1], match[2])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleCities(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("query")))
+	result := make(map[string]string)
+
+	s.mu.Lock()
+	for key, name := range s.cities {
+		if query != "" && strings.Contains(strings.ToLower(name), query) {
+			result[key] = name
+		}
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleSearch serves the result page. Pages after the last one show the last page like kleinanzeigen.de
+func (s *Server) handleSearch(w http.ResponseWriter, pageParam string, term string) {
+	page := 1
+
+	if pageParam != "" {
+		page, _ = strconv.Atoi(pageParam)
+	}
+
+	words := strings.Fields(strings.ReplaceAll(strings.ToLower(term), "-", " "))
+	matching := make([]Ad, 0)
+
+	s.mu.Lock()
+	for _, ad := range s.ads {
+		if containsAll(strings.ToLower(ad.Title), words) {
+			matching = append(matching, ad)
+		}
+	}
+	s.mu.Unlock()
+
+	lastPage := (len(matching) + PageSize - 1) / PageSize
+
+	if lastPage < 1 {
+		lastPage = 1
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	if page > lastPage {
+		page = lastPage
+	}
+
+	from := (page - 1) * PageSize
+	to := from + PageSize
+
+	if to > len(matching) {
+		to = len(matching)
+	}
+
+	data := map[string]interface{}{
+		"Term":  strings.Join(words, " "),
+		"Ads":   matching[from:to],
+		"From":  from + 1,
+		"To":    to,
+		"Total": len(matching),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := pages.ExecuteTemplate(w, "results.html", data); err != nil {
+		log.Error().Err(err).Msg("could not render fake result page")
+	}
+}
+
+func (s *Server) handleAd(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	var found *Ad
+	for i := range s.ads {
+		if s.ads[i].ID == id {
+			ad := s.ads[i]
+			found = &ad
+		}
+	}
+	s.mu.Unlock()
+
+	if found == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Die Anzeige ist nicht mehr verfügbar.")
+		return
+	}
+
+	data := map[string]interface{}{
+		"ID":          found.ID,
+		"Title":       found.Title,
+		"Price":       found.Price,
+		"Location":    found.Location,
+		"Description": found.Description,
+		"Shipping":    found.Shipping,
+		"SellerName":  found.SellerName,
+		"Commercial":  found.Commercial,
+		"PostedAt":    found.PostedAt.Format("02.01.2006"),
+		"Attributes":  found.Attributes,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := pages.ExecuteTemplate(w, "ad.html", data); err != nil {
+		log.Error().Err(err).Msg("could not render fake ad page")
+	}
+}
+
+func containsAll(title string, words []string) bool {
+	for _, word := range words {
+		if !strings.Contains(title, word) {
+			return false
+		}
+	}
+
+	return true
+}
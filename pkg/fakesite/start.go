@@ -0,0 +1,28 @@
+package fakesite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+)
+
+// Start starts a fake site for the test and registers it as the kleinanzeigen source with a fast client. The site is
+// closed and the registration is undone after the test
+func Start(t testing.TB) *Server {
+	t.Helper()
+
+	site := NewServer()
+	t.Cleanup(site.Close)
+
+	client := scraper.HTTPClient()
+	scraper.SetClient(scraper.NewClient(1000, 1000, 4, time.Second*5, "fakesite-test"))
+	scraper.Register(scraper.NewKleinanzeigen(site.BaseURL()))
+
+	t.Cleanup(func() {
+		scraper.SetClient(client)
+		scraper.Register(scraper.NewKleinanzeigen(scraper.DefaultBaseURL))
+	})
+
+	return site
+}
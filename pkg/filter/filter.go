@@ -0,0 +1,230 @@
+package filter
+
+import (
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/match"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+)
+
+// Filter decides if a scraped ad is kept
+type Filter interface {
+	Keep(ad scraper.Ad) bool
+}
+
+// Func is a function that can be used as a filter
+type Func func(ad scraper.Ad) bool
+
+// Keep calls the function
+func (f Func) Keep(ad scraper.Ad) bool {
+	return f(ad)
+}
+
+// detailFilter is implemented by filters that need the detail page of an ad
+type detailFilter interface {
+	needsDetails() bool
+}
+
+// Chain is a list of filters. An ad is kept if every filter keeps it
+type Chain []Filter
+
+// Keep returns true if every filter of the chain keeps the ad
+func (c Chain) Keep(ad scraper.Ad) bool {
+	for _, f := range c {
+		if !f.Keep(ad) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Apply returns all ads the chain keeps
+func (c Chain) Apply(ads []scraper.Ad) []scraper.Ad {
+	kept := make([]scraper.Ad, 0, len(ads))
+
+	for _, ad := range ads {
+		if c.Keep(ad) {
+			kept = append(kept, ad)
+		}
+	}
+
+	log.Debug().Int("number_of_ads", len(ads)).Int("number_of_kept_ads", len(kept)).Msg("filtered ads")
+
+	return kept
+}
+
+// NeedsDetails returns true if a filter of the chain needs the detail page of the ads
+func (c Chain) NeedsDetails() bool {
+	for _, f := range c {
+		if d, ok := f.(detailFilter); ok && d.needsDetails() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ForQuery builds the filter chain for the given query
+func ForQuery(q model.Query) Chain {
+	chain := make(Chain, 0)
+
+	if q.MaxPrice != nil {
+		chain = append(chain, MaxPrice(*q.MaxPrice))
+	}
+
+	if q.MinPrice != nil {
+		chain = append(chain, MinPrice(*q.MinPrice))
+	}
+
+	if includes := q.Includes(); len(includes) > 0 {
+		chain = append(chain, Include(includes))
+	}
+
+	if excludes := q.Excludes(); len(excludes) > 0 {
+		chain = append(chain, Exclude(excludes))
+
+		if q.ExcludeInDescription {
+			chain = append(chain, ExcludeDescription(excludes))
+		}
+	}
+
+	if q.MatchExpression != nil {
+		expr, err := match.Parse(*q.MatchExpression)
+
+		if err != nil {
+			log.Error().Err(err).Uint("query_id", q.ID).Msg("could not parse match expression of query. ignoring it")
+		} else {
+			chain = append(chain, Match(expr))
+		}
+	}
+
+	if q.LocationFilter != nil {
+		chain = append(chain, Location(*q.LocationFilter))
+	}
+
+	if q.SellerType != nil {
+		chain = append(chain, SellerType(*q.SellerType))
+	}
+
+	return chain
+}
+
+// MaxPrice drops ads with a price of at least maxPrice euros. Free ads and ads without an amount are kept
+func MaxPrice(maxPrice int) Filter {
+	return Func(func(ad scraper.Ad) bool {
+		if ad.Price.Free || !ad.Price.HasAmount() {
+			return true
+		}
+
+		return *ad.Price.Cents < int64(maxPrice)*100
+	})
+}
+
+// MinPrice drops ads with a price below minPrice euros. Free ads and ads without an amount are kept
+func MinPrice(minPrice int) Filter {
+	return Func(func(ad scraper.Ad) bool {
+		if ad.Price.Free || !ad.Price.HasAmount() {
+			return true
+		}
+
+		return *ad.Price.Upper() >= int64(minPrice)*100
+	})
+}
+
+// Include keeps ads whose title contains all of the terms. The comparison ignores the case
+func Include(terms []string) Filter {
+	return Func(func(ad scraper.Ad) bool {
+		title := strings.ToLower(ad.Title)
+
+		for _, term := range terms {
+			if !strings.Contains(title, strings.ToLower(term)) {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+// Exclude drops ads whose title contains any of the terms. The comparison ignores the case
+func Exclude(terms []string) Filter {
+	return Func(func(ad scraper.Ad) bool {
+		title := strings.ToLower(ad.Title)
+
+		for _, term := range terms {
+			if strings.Contains(title, strings.ToLower(term)) {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+type excludeDescriptionFilter struct {
+	terms []string
+}
+
+// ExcludeDescription drops ads whose description contains any of the terms. Ads without details are kept
+func ExcludeDescription(terms []string) Filter {
+	return excludeDescriptionFilter{terms: terms}
+}
+
+func (f excludeDescriptionFilter) Keep(ad scraper.Ad) bool {
+	if ad.Details == nil {
+		return true
+	}
+
+	description := strings.ToLower(ad.Details.Description)
+
+	for _, term := range f.terms {
+		if strings.Contains(description, strings.ToLower(term)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f excludeDescriptionFilter) needsDetails() bool {
+	return true
+}
+
+// Match keeps ads whose title matches the expression
+func Match(expr match.Expr) Filter {
+	return Func(func(ad scraper.Ad) bool {
+		return expr.Match(ad.Title)
+	})
+}
+
+// Location keeps ads whose location contains the given term. The comparison ignores the case
+func Location(term string) Filter {
+	return Func(func(ad scraper.Ad) bool {
+		return strings.Contains(strings.ToLower(ad.Location), strings.ToLower(strings.TrimSpace(term)))
+	})
+}
+
+type sellerTypeFilter struct {
+	sellerType string
+}
+
+// SellerType keeps ads of the given seller type. Ads without details are kept since the type is unknown
+func SellerType(sellerType string) Filter {
+	return sellerTypeFilter{sellerType: sellerType}
+}
+
+func (f sellerTypeFilter) Keep(ad scraper.Ad) bool {
+	if ad.Details == nil || ad.Details.SellerType == "" {
+		return true
+	}
+
+	return ad.Details.SellerType == f.sellerType
+}
+
+func (f sellerTypeFilter) needsDetails() bool {
+	return true
+}
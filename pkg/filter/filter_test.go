@@ -0,0 +1,184 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/match"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+)
+
+func priced(raw string) scraper.Ad {
+	return scraper.Ad{Title: "Fahrrad", Price: scraper.ParsePrice(raw)}
+}
+
+func titled(title string) scraper.Ad {
+	return scraper.Ad{Title: title}
+}
+
+func described(description string) scraper.Ad {
+	return scraper.Ad{Title: "Fahrrad", Details: &scraper.AdDetails{Description: description}}
+}
+
+func sold(sellerType string) scraper.Ad {
+	return scraper.Ad{Title: "Fahrrad", Details: &scraper.AdDetails{SellerType: sellerType}}
+}
+
+type filterCase struct {
+	name string
+	ad   scraper.Ad
+	keep bool
+}
+
+func runCases(t *testing.T, f Filter, cases []filterCase) {
+	t.Helper()
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := f.Keep(c.ad); got != c.keep {
+				t.Errorf("Keep(%+v) = %v, want %v", c.ad, got, c.keep)
+			}
+		})
+	}
+}
+
+func TestMaxPrice(t *testing.T) {
+	runCases(t, MaxPrice(100), []filterCase{
+		{"below", priced("99 €"), true},
+		{"equal", priced("100 €"), false},
+		{"above", priced("1.200 € VB"), false},
+		{"cents below", priced("99,99 €"), true},
+		{"only vb", priced("VB"), true},
+		{"empty", priced(""), true},
+		{"free", priced("Zu verschenken"), true},
+		{"range starting below", priced("50 - 200 €"), true},
+	})
+}
+
+func TestMinPrice(t *testing.T) {
+	runCases(t, MinPrice(100), []filterCase{
+		{"below", priced("99 €"), false},
+		{"equal", priced("100 €"), true},
+		{"above", priced("1.200 € VB"), true},
+		{"only vb", priced("VB"), true},
+		{"empty", priced(""), true},
+		{"free", priced("Zu verschenken"), true},
+		{"range reaching above", priced("50 - 200 €"), true},
+	})
+}
+
+func TestInclude(t *testing.T) {
+	runCases(t, Include([]string{"28", "Zoll"}), []filterCase{
+		{"all terms", titled("Damen Fahrrad 28 zoll"), true},
+		{"one term missing", titled("Damen Fahrrad 28"), false},
+		{"no term", titled("Kinderrad"), false},
+	})
+}
+
+func TestExclude(t *testing.T) {
+	runCases(t, Exclude([]string{"hülle", "Defekt"}), []filterCase{
+		{"no term", titled("iPhone 12"), true},
+		{"term", titled("iPhone 12 mit Hülle"), false},
+		{"case ignored", titled("iPhone 12 DEFEKT"), false},
+	})
+}
+
+func TestExcludeDescription(t *testing.T) {
+	f := ExcludeDescription([]string{"defekt"})
+
+	runCases(t, f, []filterCase{
+		{"clean description", described("Wie neu"), true},
+		{"term in description", described("Display ist Defekt"), false},
+		{"without details", titled("Display defekt"), true},
+	})
+
+	if !(Chain{f}).NeedsDetails() {
+		t.Error("ExcludeDescription does not need details")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	expr, err := match.Parse("(rtx 3080 OR rtx 3090) AND NOT defekt")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runCases(t, Match(expr), []filterCase{
+		{"first alternative", titled("RTX 3080 Founders"), true},
+		{"second alternative", titled("rtx 3090"), true},
+		{"excluded", titled("RTX 3080 defekt"), false},
+		{"other card", titled("RTX 3070"), false},
+	})
+}
+
+func TestLocation(t *testing.T) {
+	runCases(t, Location(" köln "), []filterCase{
+		{"contained", scraper.Ad{Location: "50733 Köln - Nippes"}, true},
+		{"other city", scraper.Ad{Location: "53111 Bonn"}, false},
+	})
+}
+
+func TestSellerType(t *testing.T) {
+	f := SellerType(scraper.SellerPrivate)
+
+	runCases(t, f, []filterCase{
+		{"private", sold(scraper.SellerPrivate), true},
+		{"commercial", sold(scraper.SellerCommercial), false},
+		{"unknown type", sold(""), true},
+		{"without details", titled("Fahrrad"), true},
+	})
+
+	if !(Chain{f}).NeedsDetails() {
+		t.Error("SellerType does not need details")
+	}
+}
+
+func TestChainApply(t *testing.T) {
+	chain := Chain{MaxPrice(100), Exclude([]string{"defekt"})}
+	ads := []scraper.Ad{
+		{ID: "1", Title: "Fahrrad", Price: scraper.ParsePrice("50 €")},
+		{ID: "2", Title: "Fahrrad defekt", Price: scraper.ParsePrice("20 €")},
+		{ID: "3", Title: "Fahrrad", Price: scraper.ParsePrice("150 €")},
+		{ID: "4", Title: "Fahrrad", Price: scraper.ParsePrice("VB")},
+	}
+
+	kept := chain.Apply(ads)
+
+	if len(kept) != 2 || kept[0].ID != "1" || kept[1].ID != "4" {
+		t.Errorf("Apply kept %+v, want the ads 1 and 4", kept)
+	}
+
+	if chain.NeedsDetails() {
+		t.Error("chain without detail filters needs details")
+	}
+
+	if got := (Chain{}).Apply(ads); len(got) != len(ads) {
+		t.Errorf("empty chain kept %d ads, want %d", len(got), len(ads))
+	}
+}
+
+func TestForQuery(t *testing.T) {
+	max, location, seller := 100, "köln", scraper.SellerPrivate
+	q := model.Query{MaxPrice: &max, LocationFilter: &location, SellerType: &seller}
+	q.SetIncludeTerms([]string{"28"})
+	q.SetExcludeTerms([]string{"defekt"})
+
+	chain := ForQuery(q)
+
+	if len(chain) != 5 {
+		t.Fatalf("ForQuery built %d filters, want 5", len(chain))
+	}
+
+	ad := scraper.Ad{Title: "Fahrrad 28 Zoll", Price: scraper.ParsePrice("50 €"), Location: "Köln"}
+
+	if !chain.Keep(ad) {
+		t.Error("chain drops a matching ad")
+	}
+
+	ad.Title = "Fahrrad 26 Zoll"
+
+	if chain.Keep(ad) {
+		t.Error("chain keeps an ad without the include term")
+	}
+}
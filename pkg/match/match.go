@@ -0,0 +1,361 @@
+package match
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed match expression
+type Expr interface {
+	// Match returns true if the text matches the expression
+	Match(text string) bool
+	String() string
+}
+
+// Parse parses a match expression. Words and quoted phrases match if the text contains them ignoring the case.
+// /regex/ and re:regex match the regular expression ignoring the case. Terms can be combined with AND, OR, NOT
+// (or UND, ODER, NICHT) in any case and grouped with parentheses. A quoted operator is a phrase. Terms without an
+// operator between them are combined with AND. NOT binds stronger than AND and AND binds stronger than OR.
+func Parse(expression string) (Expr, error) {
+	tokens, err := tokenize(expression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+
+	p := parser{tokens: tokens}
+	expr, err := p.parseOr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+
+	return expr, nil
+}
+
+type tokenKind int
+
+const (
+	tokenTerm tokenKind = iota
+	tokenRegex
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var operators = map[string]tokenKind{
+	"AND":   tokenAnd,
+	"UND":   tokenAnd,
+	"OR":    tokenOr,
+	"ODER":  tokenOr,
+	"NOT":   tokenNot,
+	"NICHT": tokenNot,
+}
+
+func tokenize(expression string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")"})
+			i++
+		case r == '"':
+			end := indexRune(runes, '"', i+1)
+
+			if end < 0 {
+				return nil, errors.New("missing closing quote")
+			}
+
+			tokens = append(tokens, token{kind: tokenTerm, text: string(runes[i+1 : end])})
+			i = end + 1
+		case r == '/':
+			end := indexRune(runes, '/', i+1)
+
+			if end < 0 {
+				return nil, errors.New("missing closing / of regex")
+			}
+
+			tokens = append(tokens, token{kind: tokenRegex, text: strings.ReplaceAll(string(runes[i+1:end]), `\/`, "/")})
+			i = end + 1
+		case strings.HasPrefix(string(runes[i:]), "re:"):
+			// the regex ends with the next space, so it may contain parentheses
+			start := i + 3
+			i = start
+
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				i++
+			}
+
+			if i == start {
+				return nil, errors.New("missing regex after re:")
+			}
+
+			tokens = append(tokens, token{kind: tokenRegex, text: string(runes[start:i])})
+		default:
+			start := i
+
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' && runes[i] != '"' {
+				i++
+			}
+
+			word := string(runes[start:i])
+
+			if kind, ok := operators[strings.ToUpper(word)]; ok {
+				tokens = append(tokens, token{kind: kind, text: word})
+			} else {
+				tokens = append(tokens, token{kind: tokenTerm, text: word})
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// indexRune finds the next unescaped rune starting at from
+func indexRune(runes []rune, r rune, from int) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == '\\' {
+			i++
+			continue
+		}
+
+		if runes[i] == r {
+			return i
+		}
+	}
+
+	return -1
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() *token {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+
+	return &p.tokens[p.pos]
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	first, err := p.parseAnd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := []Expr{first}
+
+	for t := p.peek(); t != nil && t.kind == tokenOr; t = p.peek() {
+		p.pos++
+		next, err := p.parseAnd()
+
+		if err != nil {
+			return nil, err
+		}
+
+		exprs = append(exprs, next)
+	}
+
+	if len(exprs) == 1 {
+		return first, nil
+	}
+
+	return orExpr(exprs), nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	first, err := p.parseNot()
+
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := []Expr{first}
+
+	for t := p.peek(); t != nil && t.kind != tokenOr && t.kind != tokenClose; t = p.peek() {
+		if t.kind == tokenAnd {
+			p.pos++
+		}
+
+		next, err := p.parseNot()
+
+		if err != nil {
+			return nil, err
+		}
+
+		exprs = append(exprs, next)
+	}
+
+	if len(exprs) == 1 {
+		return first, nil
+	}
+
+	return andExpr(exprs), nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	t := p.peek()
+
+	if t != nil && t.kind == tokenNot {
+		p.pos++
+		expr, err := p.parseNot()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return notExpr{expr: expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.peek()
+
+	if t == nil {
+		return nil, errors.New("unexpected end of expression")
+	}
+
+	p.pos++
+
+	switch t.kind {
+	case tokenTerm:
+		return termExpr(strings.ToLower(t.text)), nil
+	case tokenRegex:
+		re, err := regexp.Compile("(?i)" + t.text)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex /%s/: %w", t.text, err)
+		}
+
+		return regexExpr{re: re, source: t.text}, nil
+	case tokenOpen:
+		expr, err := p.parseOr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if next := p.peek(); next == nil || next.kind != tokenClose {
+			return nil, errors.New("missing closing parenthesis")
+		}
+
+		p.pos++
+		return expr, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+type termExpr string
+
+func (e termExpr) Match(text string) bool {
+	return strings.Contains(strings.ToLower(text), string(e))
+}
+
+func (e termExpr) String() string {
+	if strings.ContainsAny(string(e), " ()") {
+		return fmt.Sprintf("%q", string(e))
+	}
+
+	return string(e)
+}
+
+type regexExpr struct {
+	re     *regexp.Regexp
+	source string
+}
+
+func (e regexExpr) Match(text string) bool {
+	return e.re.MatchString(text)
+}
+
+func (e regexExpr) String() string {
+	return "/" + e.source + "/"
+}
+
+type notExpr struct {
+	expr Expr
+}
+
+func (e notExpr) Match(text string) bool {
+	return !e.expr.Match(text)
+}
+
+func (e notExpr) String() string {
+	return "NOT " + e.expr.String()
+}
+
+type andExpr []Expr
+
+func (e andExpr) Match(text string) bool {
+	for _, expr := range e {
+		if !expr.Match(text) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (e andExpr) String() string {
+	return join(e, " AND ")
+}
+
+type orExpr []Expr
+
+func (e orExpr) Match(text string) bool {
+	for _, expr := range e {
+		if expr.Match(text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e orExpr) String() string {
+	return join(e, " OR ")
+}
+
+func join(exprs []Expr, sep string) string {
+	parts := make([]string, 0, len(exprs))
+
+	for _, expr := range exprs {
+		parts = append(parts, expr.String())
+	}
+
+	return "(" + strings.Join(parts, sep) + ")"
+}
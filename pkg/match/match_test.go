@@ -0,0 +1,90 @@
+package match
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		expression string
+		matches    []string
+		rejects    []string
+	}{
+		{"fahrrad", []string{"Fahrrad 28 Zoll", "FAHRRAD"}, []string{"Roller"}},
+		{"fahrrad 28", []string{"Fahrrad 28 Zoll"}, []string{"Fahrrad 26 Zoll"}},
+		// AND binds stronger than OR
+		{"a OR b AND c", []string{"a", "b c"}, []string{"b", "c"}},
+		{"(a OR b) AND c", []string{"a c", "b c"}, []string{"a", "b"}},
+		// NOT binds stronger than AND
+		{"NOT a AND b", []string{"b"}, []string{"a b", "c"}},
+		{"NOT (a AND b)", []string{"a", "b", "c"}, []string{"a b"}},
+		{"NOT NOT a", []string{"a"}, []string{"b"}},
+		{"(rtx 3080 OR rtx 3090) AND NOT defekt", []string{"RTX 3080 OVP", "rtx 3090"}, []string{"RTX 3080 defekt", "RTX 3070"}},
+		{"((a OR b) c) OR d", []string{"a c", "d"}, []string{"a", "c"}},
+		{`"rtx 3080" NOT "3080 ti"`, []string{"RTX 3080 OVP"}, []string{"RTX 3080 Ti", "rtx 30 80"}},
+		// operators are recognized in any case, quoted they are phrases
+		{"a or b", []string{"a", "b"}, []string{"c"}},
+		{"a and not b", []string{"a"}, []string{"a b"}},
+		{"a ODER b UND c", []string{"a", "b c"}, []string{"b"}},
+		{"a oder b nicht c", []string{"a", "b"}, []string{"b c"}},
+		{`"oder"`, []string{"heute oder morgen"}, []string{"heute"}},
+		{`/rtx ?30[89]0/`, []string{"RTX3080", "rtx 3090"}, []string{"RTX 3070"}},
+		{`/a\/b/`, []string{"A/B"}, []string{"ab"}},
+		{`re:^rtx\s?3080$`, []string{"RTX 3080", "rtx3080"}, []string{"RTX 3080 Ti"}},
+		{`re:(gtx|rtx)30[89]0 NOT defekt`, []string{"GTX3090"}, []string{"rtx3080 defekt"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expression, func(t *testing.T) {
+			expr, err := Parse(c.expression)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, text := range c.matches {
+				if !expr.Match(text) {
+					t.Errorf("%s does not match %q", expr, text)
+				}
+			}
+
+			for _, text := range c.rejects {
+				if expr.Match(text) {
+					t.Errorf("%s matches %q", expr, text)
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		expression string
+		err        string
+	}{
+		{"", "empty expression"},
+		{"   ", "empty expression"},
+		{`"rtx 3080`, "missing closing quote"},
+		{"/rtx", "missing closing / of regex"},
+		{"/rtx(/", "invalid regex"},
+		{"re:rtx(", "invalid regex"},
+		{"re: rtx", "missing regex after re:"},
+		{"(a OR b", "missing closing parenthesis"},
+		{"a OR b)", `unexpected ")"`},
+		{"a AND", "unexpected end of expression"},
+		{"OR a", `unexpected "OR"`},
+		{"NOT", "unexpected end of expression"},
+		{"()", `unexpected ")"`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expression, func(t *testing.T) {
+			_, err := Parse(c.expression)
+
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("err = %v, want %q", err, c.err)
+			}
+		})
+	}
+}
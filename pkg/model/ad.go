@@ -1,12 +1,64 @@
 package model
 
-import "time"
+import (
+	"time"
 
-// Ad that is beeing stored
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+)
+
+// Ad is the snapshot of an ad found for a query. It is updated every time the ad is scraped again
 type Ad struct {
-	ID        uint   `gorm:"primary_key"`
-	EbayID    string `gorm:"type:varchar(255)"`
-	QueryID   uint   `gorm:"index:ad_queryid"`
-	Location  string `gorm:"type:varchar(510)"`
-	CreatedAt time.Time
+	ID          uint   `gorm:"primary_key"`
+	EbayID      string `gorm:"type:varchar(255);unique_index:ad_query_ebay_id"`
+	QueryID     uint   `gorm:"unique_index:ad_query_ebay_id"`
+	Title       string `gorm:"type:varchar(255)"`
+	PriceRaw    string `gorm:"type:varchar(100)"`
+	PriceCents  *int64
+	Link        string `gorm:"type:varchar(1000)"`
+	Location    string `gorm:"type:varchar(510)"`
+	SellerName  string `gorm:"type:varchar(255)"`
+	SellerType  string `gorm:"type:varchar(20)"`
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time `gorm:"index:ad_last_seen_at"`
+}
+
+// NewAd creates the snapshot of a scraped ad seen at the given time. The seller is only known if the details were fetched
+func NewAd(queryID uint, ad scraper.Ad, seenAt time.Time) Ad {
+	snapshot := Ad{
+		EbayID:      ad.ID,
+		QueryID:     queryID,
+		Title:       ad.Title,
+		PriceRaw:    ad.Price.Raw,
+		PriceCents:  ad.Price.Cents,
+		Link:        ad.Link,
+		Location:    ad.Location,
+		FirstSeenAt: seenAt,
+		LastSeenAt:  seenAt,
+	}
+
+	if ad.Details != nil {
+		snapshot.SellerName = ad.Details.SellerName
+		snapshot.SellerType = ad.Details.SellerType
+	}
+
+	return snapshot
+}
+
+// Update takes the fields of a newer snapshot of the same ad. The first-seen time is kept as well as a known seller
+// if the newer snapshot has none
+func (a *Ad) Update(newer Ad) {
+	a.Title = newer.Title
+	a.PriceRaw = newer.PriceRaw
+	a.PriceCents = newer.PriceCents
+	a.Link = newer.Link
+	a.Location = newer.Location
+	a.LastSeenAt = newer.LastSeenAt
+
+	if newer.SellerName != "" {
+		a.SellerName = newer.SellerName
+	}
+
+	if newer.SellerType != "" {
+		a.SellerType = newer.SellerType
+	}
 }
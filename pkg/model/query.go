@@ -1,26 +1,124 @@
 package model
 
 import (
+	"strings"
+	"time"
+
 	"github.com/jinzhu/gorm"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
 )
 
 // Query that is beeing sored
 type Query struct {
 	gorm.Model
-	ChatID           int64 `gorm:"index:chatid"`
-	LastAds          []Ad
-	Term             string `gorm:"type:varchar(100)"`
-	Radius           int
-	City             int
-	CityName         string `gorm:"type:varchar(100)"`
-	MaxPrice         *int
-	MinPrice         *int
-	CustomLink       *string `gorm:"type:varchar(1000)"`
-	FailedPreviously bool
+	ChatID               int64  `gorm:"index:chatid"`
+	Source               string `gorm:"type:varchar(50)"`
+	LastAds              []Ad
+	Term                 string `gorm:"type:varchar(100)"`
+	Radius               int
+	City                 int
+	CityName             string `gorm:"type:varchar(100)"`
+	MaxPrice             *int
+	MinPrice             *int
+	CustomLink           *string `gorm:"type:varchar(1000)"`
+	IncludeTerms         string  `gorm:"type:varchar(255)"`
+	ExcludeTerms         string  `gorm:"type:varchar(255)"`
+	ExcludeInDescription bool
+	MatchExpression      *string `gorm:"type:varchar(500)"`
+	LocationFilter       *string `gorm:"type:varchar(100)"`
+	SellerType           *string `gorm:"type:varchar(20)"`
+	Interval             int
+	ActiveFrom           *int
+	ActiveTo             *int
+	FailureCount         int
+	LastError            *string `gorm:"type:varchar(500)"`
+	LastFailedAt         *time.Time
+	NextRetryAt          *time.Time
+	Quarantined          bool
 }
 
+// QuarantineAfter is the number of failures in a row after which a query is paused
+const QuarantineAfter = 5
+
+// FailureBackoff is the time to wait before retrying a query after the n-th failure in a row
+var FailureBackoff = []time.Duration{time.Minute, time.Minute * 5, time.Minute * 15, time.Hour}
+
 // AfterDelete delete all assiciated ads
 func (u *Query) AfterDelete(tx *gorm.DB) (err error) {
 	tx.Where("query_id = ?", u.ID).Delete(&Ad{})
 	return
 }
+
+// Search returns the search parameters of the query for its source
+func (u *Query) Search() scraper.Search {
+	return scraper.Search{
+		Term:       u.Term,
+		CityCode:   u.City,
+		Radius:     u.Radius,
+		CustomLink: u.CustomLink,
+	}
+}
+
+// Includes returns the terms ads must contain
+func (u *Query) Includes() []string {
+	return strings.Fields(u.IncludeTerms)
+}
+
+// SetIncludeTerms sets the terms ads must contain
+func (u *Query) SetIncludeTerms(terms []string) {
+	u.IncludeTerms = strings.Join(terms, " ")
+}
+
+// Excludes returns the terms ads must not contain
+func (u *Query) Excludes() []string {
+	return strings.Fields(u.ExcludeTerms)
+}
+
+// SetExcludeTerms sets the terms ads must not contain
+func (u *Query) SetExcludeTerms(terms []string) {
+	u.ExcludeTerms = strings.Join(terms, " ")
+}
+
+// Due returns true if the query is not paused and not waiting for a retry
+func (u *Query) Due(now time.Time) bool {
+	return !u.Quarantined && (u.NextRetryAt == nil || !now.Before(*u.NextRetryAt))
+}
+
+// RetryDelay returns the time to wait after the current number of failures
+func (u *Query) RetryDelay() time.Duration {
+	if u.FailureCount <= 0 {
+		return 0
+	}
+
+	if u.FailureCount > len(FailureBackoff) {
+		return FailureBackoff[len(FailureBackoff)-1]
+	}
+
+	return FailureBackoff[u.FailureCount-1]
+}
+
+// PollInterval returns the interval the query is polled with. Queries without an interval are polled with the default interval
+func (u *Query) PollInterval(defaultInterval time.Duration) time.Duration {
+	if u.Interval <= 0 {
+		return defaultInterval
+	}
+
+	return time.Duration(u.Interval) * time.Minute
+}
+
+// Active returns true if now is within the active hours of the query. ActiveFrom and ActiveTo are minutes since midnight.
+// A range like 22:00-06:00 spans midnight. now has to be in the time zone of the active hours. Queries without active hours are always active
+func (u *Query) Active(now time.Time) bool {
+	if u.ActiveFrom == nil || u.ActiveTo == nil {
+		return true
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+
+	if *u.ActiveFrom <= *u.ActiveTo {
+		return minute >= *u.ActiveFrom && minute < *u.ActiveTo
+	}
+
+	return minute >= *u.ActiveFrom || minute < *u.ActiveTo
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// SettingTelegramOffset is the id of the next telegram update the bot has to handle
+const SettingTelegramOffset = "telegram_update_offset"
+
+// Setting is a value of the bot state that survives restarts
+type Setting struct {
+	Key       string `gorm:"primary_key;type:varchar(100)"`
+	Value     string `gorm:"type:varchar(255)"`
+	UpdatedAt time.Time
+}
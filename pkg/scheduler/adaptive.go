@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+)
+
+// adaptiveWindow is the longest time span the new ads are counted in. It is shortened to the retention of the ads,
+// since older ads are deleted from the db and could not be counted
+const adaptiveWindow = time.Hour * 24 * 7
+
+// adaptiveRefresh is the time after which the interval of a query is computed again
+const adaptiveRefresh = time.Hour
+
+// seedGrace excludes the ads stored when the query was added since they are not new listings
+const seedGrace = time.Minute * 5
+
+// pollsPerAd is the number of polls within the average time between two new ads
+const pollsPerAd = 4
+
+// Adaptive learns the polling interval of a query from the number of new ads it found recently.
+// Busy queries are polled with the min interval, queries without new ads with the max interval.
+type Adaptive struct {
+	min    time.Duration
+	max    time.Duration
+	window time.Duration
+	count  func(queryID uint, since time.Time) int
+
+	mu    sync.Mutex
+	cache map[uint]adaptiveInterval
+}
+
+type adaptiveInterval struct {
+	interval   time.Duration
+	computedAt time.Time
+}
+
+// NewAdaptive creates a new adaptive interval. count returns the number of ads stored for the query since the given time.
+// The ads are counted within the retention of the ads if it is shorter than a week
+func NewAdaptive(min time.Duration, max time.Duration, retention time.Duration, count func(queryID uint, since time.Time) int) *Adaptive {
+	if max < min {
+		max = min
+	}
+
+	window := adaptiveWindow
+
+	if retention > 0 && retention < window {
+		window = retention
+	}
+
+	return &Adaptive{
+		min:    min,
+		max:    max,
+		window: window,
+		count:  count,
+		cache:  make(map[uint]adaptiveInterval),
+	}
+}
+
+// Interval returns the learned polling interval of the query
+func (a *Adaptive) Interval(q model.Query, now time.Time) time.Duration {
+	a.mu.Lock()
+	cached, ok := a.cache[q.ID]
+	a.mu.Unlock()
+
+	if ok && now.Sub(cached.computedAt) < adaptiveRefresh {
+		return cached.interval
+	}
+
+	interval := a.compute(q, now)
+
+	a.mu.Lock()
+	a.cache[q.ID] = adaptiveInterval{interval: interval, computedAt: now}
+	a.mu.Unlock()
+
+	return interval
+}
+
+// prune forgets the intervals of queries that were removed
+func (a *Adaptive) prune(ids map[uint]bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for id := range a.cache {
+		if !ids[id] {
+			delete(a.cache, id)
+		}
+	}
+}
+
+func (a *Adaptive) compute(q model.Query, now time.Time) time.Duration {
+	since := now.Add(-a.window)
+
+	if seeded := q.CreatedAt.Add(seedGrace); seeded.After(since) {
+		since = seeded
+	}
+
+	window := now.Sub(since)
+
+	// not enough history to learn from
+	if window < time.Hour {
+		return a.min
+	}
+
+	count := a.count(q.ID, since)
+
+	if count == 0 {
+		return a.max
+	}
+
+	interval := window / time.Duration(count*pollsPerAd)
+
+	if interval < a.min {
+		interval = a.min
+	}
+
+	if interval > a.max {
+		interval = a.max
+	}
+
+	log.Debug().Uint("query_id", q.ID).Int("number_of_new_ads", count).Dur("window", window).Dur("interval", interval).Msg("computed adaptive interval")
+
+	return interval
+}
@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+)
+
+func TestAdaptiveInterval(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	week := time.Hour * 24 * 7
+
+	cases := []struct {
+		name      string
+		age       time.Duration
+		retention time.Duration
+		ads       int
+		interval  time.Duration
+		since     time.Time
+	}{
+		{"busy query", week * 2, 0, 100000, time.Minute, now.Add(-week)},
+		{"query without new ads", week * 2, 0, 0, time.Minute * 30, now.Add(-week)},
+		{"between the bounds", week * 2, 0, 112, time.Minute*22 + time.Second*30, now.Add(-week)},
+		{"one ad per hour", week * 2, 0, 168, time.Minute * 15, now.Add(-week)},
+		{"short retention", week * 2, time.Hour * 24, 24, time.Minute * 15, now.Add(-time.Hour * 24)},
+		{"long retention", week * 2, week * 4, 168, time.Minute * 15, now.Add(-week)},
+		// the ads stored when the query was added are not counted
+		{"seed grace", time.Hour*24 + seedGrace, 0, 24, time.Minute * 15, now.Add(-time.Hour * 24)},
+		{"not enough history", time.Minute * 30, 0, 0, time.Minute, time.Time{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var since time.Time
+
+			count := func(queryID uint, from time.Time) int {
+				since = from
+				return c.ads
+			}
+
+			q := query(1)
+			q.CreatedAt = now.Add(-c.age)
+
+			a := NewAdaptive(time.Minute, time.Minute*30, c.retention, count)
+
+			if interval := a.Interval(q, now); interval != c.interval {
+				t.Errorf("interval = %s, want %s", interval, c.interval)
+			}
+
+			if !since.Equal(c.since) {
+				t.Errorf("ads counted since %s, want %s", since, c.since)
+			}
+		})
+	}
+}
+
+func TestAdaptiveForgetsRemovedQueries(t *testing.T) {
+	a := NewAdaptive(time.Minute, time.Minute*30, 0, func(uint, time.Time) int { return 0 })
+	s := NewScheduler(1, time.Minute, 0, func() []model.Query { return nil }, nil)
+	s.SetAdaptive(a)
+	defer s.Stop()
+
+	now := time.Now()
+	a.Interval(query(1), now)
+	a.Interval(query(2), now)
+
+	s.prune([]model.Query{query(2)})
+
+	a.mu.Lock()
+	_, removed := a.cache[1]
+	_, kept := a.cache[2]
+	a.mu.Unlock()
+
+	if removed || !kept {
+		t.Error("the interval of the removed query was kept")
+	}
+}
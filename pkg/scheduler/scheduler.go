@@ -0,0 +1,294 @@
+package scheduler
+
+import (
+	"context"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+	// the time zone database is built in so the active hours work on systems without it
+	_ "time/tzdata"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+)
+
+// DefaultTimeZone is the time zone the active hours of the queries are evaluated in
+const DefaultTimeZone = "Europe/Berlin"
+
+// Scheduler checks every interval which queries are due and runs a job for them with a bounded number of workers.
+// A query is due if it is within its active hours and its own polling interval passed since its last run.
+// A query is never processed twice at the same time and the start of the jobs is spread with a random jitter.
+type Scheduler struct {
+	workers  int
+	interval time.Duration
+	jitter   time.Duration
+	queries  func() []model.Query
+	job      func(ctx context.Context, q model.Query)
+	allow    func() bool
+	adaptive *Adaptive
+	timeout  time.Duration
+	location *time.Location
+
+	jobs     chan model.Query
+	pending  sync.WaitGroup
+	stop     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+	inFlight map[uint]bool
+	lastRun  map[uint]time.Time
+}
+
+// NewScheduler creates a new scheduler. queries is called once per interval to get the queries to process
+func NewScheduler(workers int, interval time.Duration, jitter time.Duration, queries func() []model.Query, job func(ctx context.Context, q model.Query)) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+
+	if jitter > interval {
+		jitter = interval
+	}
+
+	location, err := time.LoadLocation(DefaultTimeZone)
+
+	if err != nil {
+		location = time.Local
+	}
+
+	return &Scheduler{
+		workers:  workers,
+		interval: interval,
+		jitter:   jitter,
+		queries:  queries,
+		job:      job,
+		location: location,
+		jobs:     make(chan model.Query),
+		stop:     make(chan struct{}),
+		inFlight: make(map[uint]bool),
+		lastRun:  make(map[uint]time.Time),
+	}
+}
+
+// SetGate sets a function that is checked before every run. No queries are scheduled while it returns false
+func (s *Scheduler) SetGate(allow func() bool) {
+	s.allow = allow
+}
+
+// SetAdaptive makes queries without an own interval use the learned interval
+func (s *Scheduler) SetAdaptive(adaptive *Adaptive) {
+	s.adaptive = adaptive
+}
+
+// SetLocation sets the time zone the active hours of the queries are evaluated in
+func (s *Scheduler) SetLocation(location *time.Location) {
+	s.location = location
+}
+
+// SetTimeout sets the deadline of a single job. 0 runs the jobs without a deadline
+func (s *Scheduler) SetTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
+
+// Run starts the workers and schedules the queries until Stop is called or the context is done. The context is passed
+// to the jobs, cancelling it aborts the running jobs. Run returns after the running jobs returned
+func (s *Scheduler) Run(ctx context.Context) {
+	var workers sync.WaitGroup
+
+	for i := 0; i < s.workers; i++ {
+		workers.Add(1)
+
+		go func() {
+			defer workers.Done()
+			s.work(ctx)
+		}()
+	}
+
+	log.Info().Int("workers", s.workers).Dur("interval", s.interval).Dur("jitter", s.jitter).Dur("timeout", s.timeout).Msg("scheduler started")
+
+	for s.running(ctx) {
+		s.schedule(ctx)
+
+		select {
+		case <-time.After(s.interval):
+		case <-ctx.Done():
+		case <-s.stop:
+		}
+	}
+
+	// jobs waiting for their jitter are dropped
+	s.pending.Wait()
+	close(s.jobs)
+	workers.Wait()
+
+	log.Info().Msg("scheduler stopped")
+}
+
+// Stop stops scheduling new jobs. The running jobs are finished
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *Scheduler) running(ctx context.Context) bool {
+	select {
+	case <-s.stop:
+		return false
+	default:
+		return ctx.Err() == nil
+	}
+}
+
+func (s *Scheduler) schedule(ctx context.Context) {
+	if s.allow != nil && !s.allow() {
+		log.Debug().Msg("scheduler is paused. skipping run")
+		return
+	}
+
+	queries := s.queries()
+	now := time.Now()
+	scheduled := 0
+
+	s.prune(queries)
+
+	for _, q := range queries {
+		if !q.Due(now) || !q.Active(now.In(s.location)) || !s.intervalPassed(q, now) || !s.acquire(q.ID) {
+			continue
+		}
+
+		s.mu.Lock()
+		s.lastRun[q.ID] = now
+		s.mu.Unlock()
+
+		scheduled++
+		delay := time.Duration(0)
+
+		if s.jitter > 0 {
+			delay = time.Duration(rand.Int63n(int64(s.jitter)))
+		}
+
+		s.pending.Add(1)
+
+		go func(query model.Query) {
+			defer s.pending.Done()
+
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				s.release(query.ID)
+				return
+			case <-s.stop:
+				s.release(query.ID)
+				return
+			}
+
+			select {
+			case s.jobs <- query:
+			case <-ctx.Done():
+				s.release(query.ID)
+			case <-s.stop:
+				s.release(query.ID)
+			}
+		}(q)
+	}
+
+	log.Info().Int("number_of_queries", len(queries)).Int("number_of_scheduled_queries", scheduled).Msg("fetching ads")
+}
+
+func (s *Scheduler) work(ctx context.Context) {
+	for q := range s.jobs {
+		s.run(ctx, q)
+	}
+}
+
+// run processes a single query with the job deadline and recovers from panics so a bad page can not kill the process
+func (s *Scheduler) run(ctx context.Context, q model.Query) {
+	defer s.release(q.ID)
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().
+				Interface("panic", r).
+				Uint("query_id", q.ID).
+				Str("stack", string(debug.Stack())).
+				Msg("recovered from panic while processing query")
+		}
+	}()
+
+	s.job(ctx, q)
+}
+
+// intervalPassed checks if the polling interval of the query passed since its last run.
+// Half of the scheduler interval is tolerated so a run is not skipped because the loop started a bit earlier.
+func (s *Scheduler) intervalPassed(q model.Query, now time.Time) bool {
+	s.mu.Lock()
+	last, ok := s.lastRun[q.ID]
+	s.mu.Unlock()
+
+	if !ok {
+		return true
+	}
+
+	return now.Sub(last)+s.interval/2 >= s.pollInterval(q, now)
+}
+
+func (s *Scheduler) pollInterval(q model.Query, now time.Time) time.Duration {
+	if q.Interval <= 0 && s.adaptive != nil {
+		return s.adaptive.Interval(q, now)
+	}
+
+	return q.PollInterval(s.interval)
+}
+
+// prune forgets the last runs and the learned intervals of queries that were removed
+func (s *Scheduler) prune(queries []model.Query) {
+	ids := make(map[uint]bool, len(queries))
+
+	for _, q := range queries {
+		ids[q.ID] = true
+	}
+
+	if s.adaptive != nil {
+		s.adaptive.prune(ids)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range s.lastRun {
+		if !ids[id] {
+			delete(s.lastRun, id)
+		}
+	}
+}
+
+// acquire marks the query as in flight. It returns false if the query is already in flight
+func (s *Scheduler) acquire(id uint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inFlight[id] {
+		log.Debug().Uint("query_id", id).Msg("query is still in flight. skipping")
+		return false
+	}
+
+	s.inFlight[id] = true
+	return true
+}
+
+func (s *Scheduler) release(id uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inFlight, id)
+}
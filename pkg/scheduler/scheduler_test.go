@@ -0,0 +1,285 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+)
+
+func query(id uint) model.Query {
+	q := model.Query{}
+	q.ID = id
+
+	return q
+}
+
+// activeAround returns a query whose active hours contain now in the location
+func activeAround(id uint, now time.Time, location *time.Location) model.Query {
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	from, to := (minute+1440-5)%1440, (minute+5)%1440
+
+	q := model.Query{ActiveFrom: &from, ActiveTo: &to}
+	q.ID = id
+
+	return q
+}
+
+// scheduleOnce runs a single scheduling run and returns the ids of the scheduled queries
+func scheduleOnce(s *Scheduler) map[uint]bool {
+	s.schedule(context.Background())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scheduled := make(map[uint]bool)
+
+	for id := range s.inFlight {
+		scheduled[id] = true
+	}
+
+	return scheduled
+}
+
+func TestActiveHoursUseLocation(t *testing.T) {
+	berlin, err := time.LoadLocation(DefaultTimeZone)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the active hours of the second query are six hours off in berlin
+	other := time.FixedZone("other", 6*60*60+berlinOffset(berlin))
+	queries := []model.Query{activeAround(1, time.Now(), berlin), activeAround(2, time.Now(), other)}
+
+	s := NewScheduler(1, time.Minute, 0, func() []model.Query { return queries }, func(ctx context.Context, q model.Query) {})
+	defer s.Stop()
+
+	scheduled := scheduleOnce(s)
+
+	if !scheduled[1] || scheduled[2] {
+		t.Errorf("scheduled %v, want only query 1 active in %s", scheduled, DefaultTimeZone)
+	}
+
+	s = NewScheduler(1, time.Minute, 0, func() []model.Query { return queries }, func(ctx context.Context, q model.Query) {})
+	s.SetLocation(other)
+	defer s.Stop()
+
+	scheduled = scheduleOnce(s)
+
+	if scheduled[1] || !scheduled[2] {
+		t.Errorf("scheduled %v, want only query 2 active in the configured location", scheduled)
+	}
+}
+
+func berlinOffset(berlin *time.Location) int {
+	_, offset := time.Now().In(berlin).Zone()
+	return offset
+}
+
+func TestScheduleForgetsRemovedQueries(t *testing.T) {
+	queries := []model.Query{query(1), query(2)}
+
+	s := NewScheduler(1, time.Minute, 0, func() []model.Query { return queries }, func(ctx context.Context, q model.Query) {})
+	defer s.Stop()
+
+	scheduleOnce(s)
+
+	s.mu.Lock()
+	runs := len(s.lastRun)
+	s.mu.Unlock()
+
+	if runs != 2 {
+		t.Fatalf("%d last runs recorded, want 2", runs)
+	}
+
+	queries = []model.Query{query(2)}
+	scheduleOnce(s)
+
+	s.mu.Lock()
+	_, removed := s.lastRun[1]
+	_, kept := s.lastRun[2]
+	s.mu.Unlock()
+
+	if removed || !kept {
+		t.Error("the last run of the removed query was kept")
+	}
+}
+
+// waitFor waits until the condition is true
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerBoundsWorkers(t *testing.T) {
+	queries := []model.Query{query(1), query(2), query(3), query(4), query(5)}
+
+	var mu sync.Mutex
+	running, max := 0, 0
+	release := make(chan struct{})
+	done := make(chan uint, len(queries))
+
+	job := func(ctx context.Context, q model.Query) {
+		mu.Lock()
+		running++
+
+		if running > max {
+			max = running
+		}
+
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		done <- q.ID
+	}
+
+	runningJobs := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return running
+	}
+
+	s := NewScheduler(2, time.Hour, 0, func() []model.Query { return queries }, job)
+	stopped := make(chan struct{})
+
+	go func() {
+		s.Run(context.Background())
+		close(stopped)
+	}()
+
+	waitFor(t, func() bool { return runningJobs() == 2 })
+
+	// the other queries wait for a free worker
+	time.Sleep(time.Millisecond * 20)
+
+	if n := runningJobs(); n != 2 {
+		t.Errorf("%d jobs running, want 2", n)
+	}
+
+	close(release)
+
+	for range queries {
+		<-done
+	}
+
+	s.Stop()
+	<-stopped
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if max != 2 {
+		t.Errorf("at most %d jobs ran at the same time, want 2", max)
+	}
+}
+
+func TestScheduleSkipsQueryInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls int32
+
+	job := func(ctx context.Context, q model.Query) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-release
+	}
+
+	s := NewScheduler(1, time.Minute, 0, func() []model.Query { return []model.Query{query(1)} }, job)
+	ctx := context.Background()
+	worked := make(chan struct{})
+
+	go func() {
+		s.work(ctx)
+		close(worked)
+	}()
+
+	s.schedule(ctx)
+	<-started
+
+	// the interval of the query passed while its fetch is still running
+	s.mu.Lock()
+	s.lastRun = make(map[uint]time.Time)
+	s.mu.Unlock()
+
+	s.schedule(ctx)
+
+	s.mu.Lock()
+	_, scheduled := s.lastRun[1]
+	s.mu.Unlock()
+
+	if scheduled {
+		t.Error("query was scheduled while its fetch was in flight")
+	}
+
+	close(release)
+	s.Stop()
+	s.pending.Wait()
+	close(s.jobs)
+	<-worked
+
+	if calls := atomic.LoadInt32(&calls); calls != 1 {
+		t.Errorf("job ran %d times, want 1", calls)
+	}
+}
+
+func TestSchedulerRecoversFromPanic(t *testing.T) {
+	processed := make(chan uint, 1)
+
+	job := func(ctx context.Context, q model.Query) {
+		if q.ID == 1 {
+			panic("broken page")
+		}
+
+		processed <- q.ID
+	}
+
+	s := NewScheduler(1, time.Minute, 0, func() []model.Query { return nil }, job)
+	worked := make(chan struct{})
+
+	go func() {
+		s.work(context.Background())
+		close(worked)
+	}()
+
+	if !s.acquire(1) || !s.acquire(2) {
+		t.Fatal("queries are already in flight")
+	}
+
+	s.jobs <- query(1)
+	s.jobs <- query(2)
+	close(s.jobs)
+	<-worked
+
+	// the worker survived the panic and processed the next query
+	if id := <-processed; id != 2 {
+		t.Errorf("processed query %d, want 2", id)
+	}
+
+	s.mu.Lock()
+	inFlight := s.inFlight[1]
+	s.mu.Unlock()
+
+	if inFlight {
+		t.Error("the query that panicked is still in flight")
+	}
+}
@@ -0,0 +1,160 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/rs/zerolog/log"
+)
+
+// SellerPrivate is the seller type of private sellers
+const SellerPrivate = "private"
+
+// SellerCommercial is the seller type of commercial sellers
+const SellerCommercial = "commercial"
+
+const postingDateLayout = "02.01.2006"
+
+// AdDetails is a representation of the detail page of a kleinanzeigen ad
+type AdDetails struct {
+	ID          string
+	Title       string
+	Price       Price
+	Location    string
+	Description string
+	Images      []string
+	SellerName  string
+	SellerType  string
+	MemberSince string
+	Shipping    string
+	PostedAt    *time.Time
+	Attributes  map[string]string
+}
+
+// GetAdDetails fetches the detail page of the ad with the given link
+func (k *Kleinanzeigen) GetAdDetails(ctx context.Context, link string) (*AdDetails, error) {
+	log.Debug().Str("link", link).Msg("scraping ad details")
+
+	if !strings.HasPrefix(link, k.baseURL) {
+		return nil, errors.New("invalid ad link")
+	}
+
+	res, err := HTTPClient().Get(ctx, link, nil)
+
+	if err != nil {
+		log.Error().Err(err).Str("link", link).Msg("error while scraping ad details")
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		log.Error().Str("status_code", res.Status).Str("link", link).Msg("error while scraping ad details")
+		return nil, statusError(res)
+	}
+
+	return ParseAdDetails(bytes.NewReader(res.Body))
+}
+
+// ParseAdDetails parses the html of an ad detail page
+func ParseAdDetails(r io.Reader) (*AdDetails, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	sel := CurrentSelectors()
+
+	if doc.Find(sel.DetailTitle).Length() == 0 {
+		return nil, fmt.Errorf("%w: page is not an ad detail page", ErrParse)
+	}
+
+	details := AdDetails{
+		ID:          cleanText(doc.Find(sel.DetailID).Last().Text()),
+		Title:       cleanText(doc.Find(sel.DetailTitle).Text()),
+		Price:       ParsePrice(doc.Find(sel.DetailPrice).Text()),
+		Location:    cleanText(doc.Find(sel.DetailLocation).Text()),
+		Description: descriptionText(doc.Find(sel.DetailDescription)),
+		Images:      make([]string, 0, 0),
+		Shipping:    cleanText(doc.Find(sel.DetailShipping).Text()),
+		Attributes:  make(map[string]string),
+	}
+
+	doc.Find(sel.DetailImages).Each(func(_ int, img *goquery.Selection) {
+		src, exists := img.Attr("data-imgsrc")
+
+		if !exists {
+			src, exists = img.Attr("src")
+		}
+
+		if exists && src != "" {
+			details.Images = append(details.Images, src)
+		}
+	})
+
+	contact := doc.Find(sel.DetailContact)
+	details.SellerName = cleanText(contact.Find(sel.DetailSellerName).First().Text())
+
+	contact.Find(sel.DetailSellerDetails).Each(func(_ int, e *goquery.Selection) {
+		text := cleanText(e.Text())
+		lower := strings.ToLower(text)
+
+		switch {
+		case strings.HasPrefix(lower, "privater nutzer"):
+			details.SellerType = SellerPrivate
+		case strings.HasPrefix(lower, "gewerblicher nutzer"):
+			details.SellerType = SellerCommercial
+		case strings.HasPrefix(lower, "aktiv seit"):
+			details.MemberSince = strings.TrimSpace(text[len("aktiv seit"):])
+		}
+	})
+
+	doc.Find(sel.DetailPostedAt).EachWithBreak(func(_ int, e *goquery.Selection) bool {
+		postedAt, err := time.ParseInLocation(postingDateLayout, cleanText(e.Text()), time.Local)
+
+		if err != nil {
+			return true
+		}
+
+		details.PostedAt = &postedAt
+		return false
+	})
+
+	doc.Find(sel.DetailAttributes).Each(func(_ int, e *goquery.Selection) {
+		value := cleanText(e.Find(sel.DetailAttributeValue).Text())
+		key := cleanText(strings.Replace(e.Text(), e.Find(sel.DetailAttributeValue).Text(), "", 1))
+
+		if key != "" {
+			details.Attributes[key] = value
+		}
+	})
+
+	return &details, nil
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func cleanText(s string) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(s, " "))
+}
+
+// descriptionText keeps the line breaks of the description
+func descriptionText(s *goquery.Selection) string {
+	s.Find("br").ReplaceWithHtml("\n")
+
+	lines := strings.Split(s.Text(), "\n")
+	cleaned := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		cleaned = append(cleaned, cleanText(line))
+	}
+
+	return strings.TrimSpace(strings.Join(cleaned, "\n"))
+}
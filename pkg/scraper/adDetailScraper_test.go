@@ -0,0 +1,99 @@
+package scraper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseAdDetails(t *testing.T) {
+	tests := map[string]AdDetails{
+		"damen-fahrrad.html": {
+			ID:          "2591234567",
+			Title:       "Damen Fahrrad 28 Zoll",
+			Location:    "50733 Köln - Nippes",
+			Description: "Gut erhaltenes Damenrad mit 7 Gängen.\nLicht funktioniert.\n\nNur Abholung oder Versand.",
+			Images: []string{
+				"https://img.kleinanzeigen.de/api/v1/prod-ads/images/ab/ab1.JPG?rule=$_59.JPG",
+				"https://img.kleinanzeigen.de/api/v1/prod-ads/images/cd/cd2.JPG?rule=$_59.JPG",
+			},
+			SellerName:  "Anna",
+			SellerType:  SellerPrivate,
+			MemberSince: "12.03.2015",
+			Shipping:    "+ Versand ab 35,49 €",
+			Attributes:  map[string]string{"Art": "Damen", "Typ": "Citybike"},
+		},
+	}
+
+	pages, err := filepath.Glob(filepath.Join("fixtures", "details", "*.html"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pages) == 0 {
+		t.Fatal("no detail fixtures found")
+	}
+
+	for _, page := range pages {
+		name := filepath.Base(page)
+
+		t.Run(name, func(t *testing.T) {
+			want, ok := tests[name]
+
+			if !ok {
+				t.Fatalf("no expectation for fixture %s", name)
+			}
+
+			file, err := os.Open(page)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer file.Close()
+
+			got, err := ParseAdDetails(file)
+
+			if err != nil {
+				t.Fatalf("could not parse: %v", err)
+			}
+
+			checks := []struct {
+				field     string
+				got, want interface{}
+			}{
+				{"ID", got.ID, want.ID},
+				{"Title", got.Title, want.Title},
+				{"Location", got.Location, want.Location},
+				{"Description", got.Description, want.Description},
+				{"Images", got.Images, want.Images},
+				{"SellerName", got.SellerName, want.SellerName},
+				{"SellerType", got.SellerType, want.SellerType},
+				{"MemberSince", got.MemberSince, want.MemberSince},
+				{"Shipping", got.Shipping, want.Shipping},
+				{"Attributes", got.Attributes, want.Attributes},
+			}
+
+			for _, c := range checks {
+				if !reflect.DeepEqual(c.got, c.want) {
+					t.Errorf("%s = %#v, want %#v", c.field, c.got, c.want)
+				}
+			}
+
+			if got.Price.Cents == nil || *got.Price.Cents != 12000 || !got.Price.Negotiable {
+				t.Errorf("Price = %+v, want 120 € VB", got.Price)
+			}
+
+			if got.PostedAt == nil {
+				t.Fatal("PostedAt is nil")
+			}
+
+			// the date is parsed in the local time zone
+			if y, m, d := got.PostedAt.Date(); y != 2026 || m != 10 || d != 17 {
+				t.Errorf("PostedAt = %v, want 17.10.2026", got.PostedAt)
+			}
+		})
+	}
+}
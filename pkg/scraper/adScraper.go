@@ -1,191 +1,332 @@
 package scraper
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
+	neturl "net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/rs/zerolog/log"
-
-	"github.com/gocolly/colly"
 )
 
-const url = "https://www.kleinanzeigen.de/seite:%v/s-%s/k0l%vr%v"
+// DefaultBaseURL is the base url of kleinanzeigen.de
+const DefaultBaseURL = "https://www.kleinanzeigen.de/"
+
+const url = "seite:%v/s-%s/k0l%vr%v"
 
-const cityURL = "https://www.kleinanzeigen.de/s-ort-empfehlungen.json?query=%s"
+const cityURL = "s-ort-empfehlungen.json?query=%s"
 
-// Ad is a representation of the kleinanzeigen ads
-type Ad struct {
-	Title    string
-	Link     string
-	Price    string
-	Location string
-	ID       string
+// DefaultCityUserAgent is the user agent of the city lookup. The lookup is answered for browsers only
+const DefaultCityUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:74.0) Gecko/20100101 Firefox/74.0"
+
+// Kleinanzeigen is the source for kleinanzeigen.de
+type Kleinanzeigen struct {
+	baseURL       string
+	linkRegex     *regexp.Regexp
+	cityUserAgent string
+	cityTimeout   time.Duration
+}
+
+func init() {
+	Register(NewKleinanzeigen(DefaultBaseURL))
+}
+
+// NewKleinanzeigen creates the kleinanzeigen source for the given base url. Other base urls than DefaultBaseURL are used for tests
+func NewKleinanzeigen(baseURL string) *Kleinanzeigen {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	return &Kleinanzeigen{
+		baseURL:       baseURL,
+		linkRegex:     regexp.MustCompile("^" + regexp.QuoteMeta(baseURL) + `s-[^? ]+`),
+		cityUserAgent: DefaultCityUserAgent,
+	}
+}
+
+// SetCityLookup sets the user agent and the timeout of the city lookup. A timeout of 0 only uses the client timeout
+func (k *Kleinanzeigen) SetCityLookup(userAgent string, timeout time.Duration) {
+	k.cityUserAgent = userAgent
+	k.cityTimeout = timeout
+}
+
+// Name returns the name of the source
+func (k *Kleinanzeigen) Name() string {
+	return DefaultSource
+}
+
+// SearchURL builds the normalized url of the result page. The site ignores the case and surrounding spaces of the term
+func (k *Kleinanzeigen) SearchURL(page int, search Search) string {
+	if (search.CustomLink != nil) && k.CheckLink(*search.CustomLink) {
+		return strings.TrimSuffix(k.pageLink(*search.CustomLink, page), "/")
+	}
+
+	term := strings.Join(strings.Fields(strings.ToLower(search.Term)), "-")
+
+	return k.baseURL + fmt.Sprintf(url, page, term, search.CityCode, search.Radius)
 }
 
 // GetAds gets the ads for the specified page serachterm citycode and radius
-func GetAds(page int, term string, cityCode int, radius int, maxPrice *int, minPrice *int, customLink *string) ([]Ad, error) {
+func (k *Kleinanzeigen) GetAds(ctx context.Context, page int, search Search) ([]Ad, error) {
 	log.Debug().Msg("scraping for ads")
-	query := fmt.Sprintf(url, page, strings.ReplaceAll(term, " ", "-"), cityCode, radius)
+	term, radius := search.Term, search.Radius
+	query := k.SearchURL(page, search)
 
-	if (customLink != nil) && CheckUrl(*customLink) {
-		query = *customLink
+	res, err := HTTPClient().Get(ctx, query, nil)
+
+	if err != nil {
+		log.Error().Err(err).Str("term", term).Int("radius", radius).Msg("error while scraping for ads")
+		return nil, err
 	}
 
-	ads := make([]Ad, 0, 0)
-	c := colly.NewCollector(
-		colly.UserAgent("telegram-alert-bot/1.0"),
-	)
-
-	c.OnHTML("#srchrslt-adtable", func(adListEl *colly.HTMLElement) {
-		adListEl.ForEach(".ad-listitem", func(_ int, e *colly.HTMLElement) {
-			if !strings.Contains(e.DOM.Nodes[0].Attr[0].Val, "is-topad") {
-				link := e.DOM.Find("a[class=ellipsis]")
-				linkURL, _ := link.Attr("href")
-				price := strings.TrimSpace(e.DOM.Find("p[class=aditem-main--middle--price-shipping--price]").Text())
-
-				space := regexp.MustCompile(`\s+`)
-				location := strings.TrimSpace(e.DOM.Find("div [class=aditem-main--top--left]").Last().Text())
-
-				location = space.ReplaceAllString(location, " ")
-
-				if maxPrice != nil && strings.ToLower(price) != "zu verschenken" {
-					replacted := strings.Trim(strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(strings.Trim(price, " "), "VB", ""), "€", ""), ".", ""), " ")
-
-					if len(replacted) == 0 {
-						return
-					}
-
-					priceValue, err := strconv.Atoi(replacted)
-
-					if err != nil {
-						log.Warn().Str("price-string", replacted).Msg("could not parse price from ad")
-						return
-					}
-
-					if priceValue >= *maxPrice {
-						log.Debug().Msg("price is bigger than requested")
-						return
-					}
-
-					if minPrice != nil && priceValue < *minPrice {
-						log.Debug().Msg("price is lower than requested")
-						return
-					}
-				}
-
-				id, idExsits := e.DOM.Find("article[class=aditem]").Attr("data-adid")
-				//details := e.DOM.Find("div[class=aditem-details]")
-				title := link.Text()
-				if idExsits {
-					ads = append(ads, Ad{Title: title, Link: "https://www.kleinanzeigen.de" + linkURL, ID: id, Price: price, Location: location})
-				}
-			}
-		})
-	})
+	if res.StatusCode != http.StatusOK {
+		err := statusError(res)
 
-	var err error
-	c.OnError(func(r *colly.Response, e error) {
-		log.Error().Err(e).Str("term", term).Int("radius", radius).Msg("error while scraping for ads")
-		if e.Error() == "Forbidden" {
-			err = errors.New("Forbidden")
+		if errors.Is(err, ErrBlocked) || errors.Is(err, ErrRateLimited) {
+			log.Error().Str("status_code", res.Status).Str("term", term).Int("radius", radius).Msg("ip address might be blocked by kleinanzeigen.")
+		} else {
+			log.Error().Str("status_code", res.Status).Str("term", term).Int("radius", radius).Msg("error while scraping for ads")
 		}
-	})
 
-	c.Visit(query)
+		return nil, err
+	}
+
+	if isBlockedPage(res.Body) {
+		log.Error().Str("term", term).Int("radius", radius).Msg("received a captcha page. ip address might be blocked by kleinanzeigen.")
+		return nil, ErrBlocked
+	}
+
+	ads, err := ParseAds(bytes.NewReader(res.Body), k.baseURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := CheckLayout(res.Body, ads); err != nil {
+		path, dumpErr := dumpPage(res.Body, page)
+
+		if dumpErr != nil {
+			log.Error().Err(dumpErr).Msg("could not save the page")
+		}
+
+		log.Error().Err(err).Str("url", query).Str("saved_page", path).Msg("layout of kleinanzeigen might have changed")
+
+		if path != "" {
+			return nil, fmt.Errorf("%w (page saved to %s)", err, path)
+		}
 
-	c.Wait()
+		return nil, err
+	}
 
 	log.Debug().Str("query", term).Int("number_of_queries", len(ads)).Msg("scraped ads for query")
 
-	if len(ads) == 0 && err != nil {
-		return ads, err
+	return ads, nil
+}
+
+// ParseAds parses the ads of a result page. The links of the ads are relative to the base url
+func ParseAds(r io.Reader, baseURL string) ([]Ad, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrParse, err)
 	}
 
+	sel := CurrentSelectors()
+	ads := make([]Ad, 0, 0)
+
+	doc.Find(sel.ResultItem).Each(func(_ int, e *goquery.Selection) {
+		if e.HasClass(sel.TopAdClass) {
+			return
+		}
+
+		link := e.Find(sel.ResultLink)
+		linkURL, _ := link.Attr("href")
+		price := ParsePrice(e.Find(sel.ResultPrice).Text())
+
+		location := cleanText(e.Find(sel.ResultLocation).Last().Text())
+
+		id, idExsits := e.Find(sel.ResultID).Attr(sel.ResultIDAttribute)
+		title := link.Text()
+		if idExsits {
+			ads = append(ads, Ad{Title: title, Link: baseURL + strings.TrimPrefix(linkURL, "/"), ID: id, Price: price, Location: location})
+		}
+	})
+
 	return ads, nil
 }
 
-// FindCityID finds the city by the name/postal code
-func FindCityID(untrimmed string) (int, string, error) {
+// FindCity finds the city by the name/postal code. If several cities match, the one with exactly the given name is used.
+// Otherwise a CityError wrapping ErrCityAmbiguous with the candidates is returned
+func (k *Kleinanzeigen) FindCity(ctx context.Context, untrimmed string) (int, string, error) {
 	log.Debug().Str("city_search_term", untrimmed).Msg("finding city id")
 
 	city := strings.Trim(untrimmed, " ")
 
-	spaceClient := http.Client{
-		Timeout: time.Second * 2,
+	if k.cityTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, k.cityTimeout)
+		defer cancel()
 	}
 
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(cityURL, city), nil)
+	res, err := HTTPClient().Get(ctx, k.baseURL+fmt.Sprintf(cityURL, neturl.QueryEscape(city)), map[string]string{
+		"User-Agent":      k.cityUserAgent,
+		"Accept":          "*/*",
+		"Accept-Language": "en-US,en;q=0.5",
+	})
 
 	if err != nil {
-		log.Error().Err(err).Msg("could not create the request")
-		return 0, "", errors.New("could not make request")
+		return 0, "", fmt.Errorf("could not send request: %w", err)
 	}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:74.0) Gecko/20100101 Firefox/74.0")
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
+	if res.StatusCode != http.StatusOK {
+		log.Error().Str("status_code", res.Status).Msg("received a wrong status code.")
+		err := statusError(res)
 
-	res, getErr := spaceClient.Do(req)
+		if errors.Is(err, ErrBlocked) || errors.Is(err, ErrRateLimited) {
+			log.Error().Msg("ip address might be blocked by kleinanzeigen.")
+		}
 
-	if getErr != nil {
-		return 0, "", errors.New("could not send request")
+		return 0, "", err
 	}
 
-	if res.StatusCode != 200 {
-		log.Error().Str("status_code", res.Status).Msg("received a wrong status code.")
-		if res.StatusCode == 403 {
-			log.Error().Msg("ip address might be blocked by kleinanzeigen.")
+	var cities map[string]string
+
+	if err := json.Unmarshal(res.Body, &cities); err != nil {
+		return 0, "", fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	if len(cities) == 0 {
+		return 0, "", &CityError{City: city, Err: ErrNotFound}
+	}
+
+	key, name, ok := pickCity(city, cities)
+
+	if !ok {
+		names := make([]string, 0, len(cities))
+
+		for _, name := range cities {
+			names = append(names, name)
 		}
-		return 0, "", errors.New("request for city not successful")
+
+		sort.Strings(names)
+
+		return 0, "", &CityError{City: city, Candidates: names, Err: ErrCityAmbiguous}
 	}
 
-	body, readErr := ioutil.ReadAll(res.Body)
+	cityID, err := strconv.Atoi(strings.Trim(string([]rune(key)[1:]), " "))
 
-	if readErr != nil {
-		return 0, "", errors.New("could not read response")
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: invalid city id %q", ErrParse, key)
 	}
 
-	var cities map[string]string
+	log.Debug().Int("city_id", cityID).Str("city_name", name).Msg("found city")
+
+	return cityID, name, nil
+}
 
-	unmarshalErr := json.Unmarshal(body, &cities)
+var postalCodeRegex = regexp.MustCompile(`^(\d{4,5})\s+(.*)$`)
 
-	if unmarshalErr != nil {
-		return 0, "", errors.New("could not parse json")
+// pickCity picks the only city, the city with exactly the searched name or the only city whose name or postal code starts
+// with the searched city. ok is false if several cities match equally well
+func pickCity(city string, cities map[string]string) (string, string, bool) {
+	if len(cities) == 1 {
+		for key, name := range cities {
+			return key, name, true
+		}
 	}
 
-	if len(cities) == 0 {
-		return 0, "", errors.New("could not find city")
+	search := strings.ToLower(strings.TrimSpace(city))
+
+	// the matchers are tried from strict to loose. names may start with a postal code like "50667 Köln"
+	matchers := []func(name string, place string) bool{
+		func(name string, place string) bool {
+			return name == search || place == search
+		},
+		func(name string, place string) bool {
+			return strings.HasPrefix(name, search) || strings.HasPrefix(place, search)
+		},
 	}
 
-	for key, value := range cities {
-		cityIDString := []rune(key)
+	for _, matches := range matchers {
+		key, name, found := "", "", 0
+
+		for k, n := range cities {
+			lower := strings.ToLower(strings.TrimSpace(n))
+			place := lower
 
-		cityID, err := strconv.Atoi(strings.Trim(string(cityIDString[1:]), " "))
+			if match := postalCodeRegex.FindStringSubmatch(lower); match != nil {
+				place = match[2]
+			}
 
-		if err != nil {
-			return 0, "", errors.New("could not get cityId")
+			if matches(lower, place) {
+				key, name = k, n
+				found++
+			}
 		}
 
-		log.Debug().Int("city_id", cityID).Str("city_name", value).Msg("found city")
+		if found == 1 {
+			return key, name, true
+		}
 
-		return cityID, value, nil
+		if found > 1 {
+			return "", "", false
+		}
 	}
 
-	return 0, "", errors.New("no city id found")
+	return "", "", false
 }
 
-// CheckUrl checks if a url is valid
-func CheckUrl(untrimmed string) bool {
-	url := strings.Trim(untrimmed, " ")
+// blockMarkers are parts of the captcha and challenge pages served instead of the results when the bot is blocked
+var blockMarkers = [][]byte{
+	[]byte("captcha-delivery.com"),
+	[]byte("g-recaptcha"),
+	[]byte("h-captcha"),
+	[]byte("cf-challenge"),
+	[]byte("challenge-platform"),
+	[]byte("<title>access denied</title>"),
+}
+
+// isBlockedPage detects captcha and challenge pages. Pages with an unknown layout are left to CheckLayout
+func isBlockedPage(body []byte) bool {
+	lower := bytes.ToLower(body)
 
-	var urlRegex = regexp.MustCompile(`https://www.kleinanzeigen.de/s-[^? ]+`)
+	for _, marker := range blockMarkers {
+		if bytes.Contains(lower, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pageLink inserts the page into a custom search link
+func (k *Kleinanzeigen) pageLink(link string, page int) string {
+	trimmed := strings.Trim(link, " ")
+
+	if page <= 1 || !strings.HasPrefix(trimmed, k.baseURL) {
+		return trimmed
+	}
+
+	path := strings.TrimPrefix(trimmed, k.baseURL)
+	path = regexp.MustCompile(`^seite:\d+/`).ReplaceAllString(path, "")
+	path = regexp.MustCompile(`/seite:\d+/`).ReplaceAllString(path, "/")
+
+	return fmt.Sprintf("%sseite:%d/%s", k.baseURL, page, path)
+}
+
+// CheckLink checks if a url is valid
+func (k *Kleinanzeigen) CheckLink(untrimmed string) bool {
+	url := strings.Trim(untrimmed, " ")
 
-	return urlRegex.Match([]byte(url))
+	return k.linkRegex.Match([]byte(url))
 }
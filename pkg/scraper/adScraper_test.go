@@ -0,0 +1,47 @@
+package scraper
+
+import "testing"
+
+func TestPickCity(t *testing.T) {
+	cities := map[string]string{
+		"_945":  "Köln",
+		"_9386": "50667 Köln - Altstadt-Nord",
+		"_9387": "50668 Köln - Neustadt-Nord",
+		"_1234": "Frankfurt am Main",
+		"_1235": "Frankfurt (Oder)",
+		"_2000": "Mönchengladbach",
+		"_3331": "Berlin",
+		"_3332": "Bernau bei Berlin",
+	}
+
+	cases := []struct {
+		city string
+		key  string
+		ok   bool
+	}{
+		{"Köln", "_945", true},
+		{" köln ", "_945", true},
+		{"50667", "_9386", true},
+		{"5066", "", false},
+		{"Mönchen", "_2000", true},
+		{"frankfurt am", "_1234", true},
+		{"Frankfurt", "", false},
+		{"Berlin", "_3331", true},
+		{"Ber", "", false},
+		{"Hamburg", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.city, func(t *testing.T) {
+			key, _, ok := pickCity(c.city, cities)
+
+			if ok != c.ok || (c.ok && key != c.key) {
+				t.Errorf("pickCity(%q) = %q %v, want %q %v", c.city, key, ok, c.key, c.ok)
+			}
+		})
+	}
+
+	if key, _, ok := pickCity("irgendwas", map[string]string{"_945": "Köln"}); !ok || key != "_945" {
+		t.Errorf("the only city was not picked: %q %v", key, ok)
+	}
+}
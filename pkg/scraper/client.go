@@ -0,0 +1,257 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Client is the http client shared by all requests to the scraped sites. It limits the request rate per host with a
+// token bucket and the number of concurrent requests, and reuses the connections.
+type Client struct {
+	http        *http.Client
+	userAgent   string
+	concurrency int
+	timeout     time.Duration
+	sem         chan struct{}
+	rate        float64
+	burst       float64
+	proxies     *ProxyPool
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Response is the response of a request made with the client
+type Response struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+var clientMu sync.RWMutex
+var sharedClient = NewClient(1, 5, 4, time.Second*10, "telegram-alert-bot/1.0")
+
+// SetClient replaces the client used by the sources
+func SetClient(c *Client) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+
+	sharedClient = c
+}
+
+// HTTPClient returns the client used by the sources
+func HTTPClient() *Client {
+	clientMu.RLock()
+	defer clientMu.RUnlock()
+
+	return sharedClient
+}
+
+// NewClient creates a new client. ratePerSecond is the number of requests per second and host, burst the number of
+// requests that can be made at once after a pause and concurrency the number of requests running at the same time
+func NewClient(ratePerSecond float64, burst int, concurrency int, timeout time.Duration, userAgent string) *Client {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Client{
+		http: &http.Client{
+			Transport: newTransport(http.ProxyFromEnvironment, concurrency, timeout),
+			Timeout:   timeout,
+		},
+		userAgent:   userAgent,
+		concurrency: concurrency,
+		timeout:     timeout,
+		sem:         make(chan struct{}, concurrency),
+		rate:        ratePerSecond,
+		burst:       float64(burst),
+		buckets:     make(map[string]*bucket),
+	}
+}
+
+func newTransport(proxy func(*http.Request) (*neturl.URL, error), concurrency int, timeout time.Duration) *http.Transport {
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: time.Second * 30,
+		}).DialContext,
+		MaxIdleConns:          concurrency * 2,
+		MaxIdleConnsPerHost:   concurrency,
+		IdleConnTimeout:       time.Second * 90,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		// the transport requests gzip and decompresses the body transparently
+		DisableCompression: false,
+	}
+}
+
+// UseProxies sends all requests through the given http, https or socks5 proxies. Proxies that fail are skipped for the cooldown
+func (c *Client) UseProxies(proxyURLs []string, cooldown time.Duration) error {
+	pool, err := newProxyPool(proxyURLs, cooldown, c.concurrency, c.timeout)
+
+	if err != nil {
+		return err
+	}
+
+	c.proxies = pool
+	c.http.Transport = pool
+	// the transports of the proxies time out on their own, so a timed out request can be retried on the next proxy.
+	// the body is read with its own timeout
+	c.http.Timeout = 0
+
+	return nil
+}
+
+// ProxyStats returns the statistics of the proxies of the client. nil if no proxies are used
+func (c *Client) ProxyStats() []ProxyStats {
+	if c.proxies == nil {
+		return nil
+	}
+
+	return c.proxies.Stats()
+}
+
+// Get requests the url and reads the body. The given headers are added to the request. Waiting for the rate limit and
+// the request are cancelled with the context. An exceeded deadline is returned as ErrTimeout
+func (c *Client) Get(ctx context.Context, rawURL string, header map[string]string) (*Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+
+	if err != nil {
+		log.Error().Err(err).Str("url", rawURL).Msg("could not create the request")
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+
+	if err := c.wait(ctx, req.URL); err != nil {
+		return nil, contextError(err)
+	}
+
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, contextError(ctx.Err())
+	}
+
+	defer func() { <-c.sem }()
+
+	res, err := c.http.Do(req)
+
+	if err != nil {
+		var netErr net.Error
+
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return nil, fmt.Errorf("%w: %v", ErrTimeout, err)
+		}
+
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	body, err := c.readBody(res, cancel)
+
+	if err != nil {
+		return nil, contextError(fmt.Errorf("could not read response: %w", err))
+	}
+
+	return &Response{StatusCode: res.StatusCode, Status: res.Status, Body: body}, nil
+}
+
+// readBody reads the body of the response. Without an overall timeout of the http client, i.e. with proxies, the read
+// is aborted with cancel after the timeout of the client
+func (c *Client) readBody(res *http.Response, cancel context.CancelFunc) ([]byte, error) {
+	if c.http.Timeout > 0 || c.timeout <= 0 {
+		return ioutil.ReadAll(res.Body)
+	}
+
+	timer := time.AfterFunc(c.timeout, cancel)
+	body, err := ioutil.ReadAll(res.Body)
+
+	if !timer.Stop() && err != nil {
+		return nil, fmt.Errorf("%w: reading the response took longer than %s", ErrTimeout, c.timeout)
+	}
+
+	return body, err
+}
+
+// wait blocks until the token bucket of the host allows another request or the context is done
+func (c *Client) wait(ctx context.Context, u *neturl.URL) error {
+	if c.rate <= 0 {
+		return nil
+	}
+
+	for {
+		c.mu.Lock()
+
+		now := time.Now()
+		b, ok := c.buckets[u.Host]
+
+		if !ok {
+			b = &bucket{tokens: c.burst, last: now}
+			c.buckets[u.Host] = b
+		}
+
+		b.tokens += now.Sub(b.last).Seconds() * c.rate
+		b.last = now
+
+		if b.tokens > c.burst {
+			b.tokens = c.burst
+		}
+
+		if b.tokens >= 1 {
+			b.tokens--
+			c.mu.Unlock()
+			return nil
+		}
+
+		delay := time.Duration((1 - b.tokens) / c.rate * float64(time.Second))
+		c.mu.Unlock()
+
+		log.Debug().Str("host", u.Host).Dur("delay", delay).Msg("rate limit reached. waiting")
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// contextError returns an exceeded deadline as ErrTimeout
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+
+	return err
+}
@@ -0,0 +1,121 @@
+package scraper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientLimitsRate(t *testing.T) {
+	server := httptest.NewServer(answer(http.StatusOK, "ok"))
+	defer server.Close()
+
+	// a burst of 2 requests, then 20 requests per second
+	client := NewClient(20, 2, 4, time.Second*5, "client-test")
+	start := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get(context.Background(), server.URL, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Millisecond*40 {
+		t.Errorf("burst took %s, want no delay", elapsed)
+	}
+
+	for i := 0; i < 6; i++ {
+		if _, err := client.Get(context.Background(), server.URL, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the 6 requests after the burst need 300ms at 20 requests per second
+	if elapsed := time.Since(start); elapsed < time.Millisecond*280 || elapsed > time.Second*2 {
+		t.Errorf("8 requests took %s, want about 300ms", elapsed)
+	}
+
+	// waiting for the rate limit is cancelled with the context
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	client = NewClient(0.1, 1, 4, time.Second*5, "client-test")
+	client.Get(context.Background(), server.URL, nil)
+
+	if _, err := client.Get(ctx, server.URL, nil); err == nil {
+		t.Error("request was sent before the rate limit allowed it")
+	}
+}
+
+func TestClientLimitsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	active, max := 0, 0
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+
+		if active > max {
+			max = active
+		}
+
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	activeRequests := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return active
+	}
+
+	client := NewClient(0, 1, 3, time.Second*5, "client-test")
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := client.Get(context.Background(), server.URL, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+
+	for activeRequests() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the other requests wait for a free slot
+	time.Sleep(time.Millisecond * 20)
+
+	if n := activeRequests(); n != 3 {
+		t.Errorf("%d requests running, want 3", n)
+	}
+
+	close(release)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if max != 3 {
+		t.Errorf("at most %d requests ran at the same time, want 3", max)
+	}
+}
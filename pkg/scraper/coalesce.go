@@ -0,0 +1,140 @@
+package scraper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// coalescedSource fetches identical result pages once within the ttl and shares them with every query of the same search
+type coalescedSource struct {
+	Source
+	ttl time.Duration
+
+	mu    sync.Mutex
+	pages map[string]*coalescedPage
+}
+
+type coalescedPage struct {
+	done      chan struct{}
+	ads       []Ad
+	err       error
+	cancelled bool
+	fetchedAt time.Time
+}
+
+// sharedError is the error of a request that is returned to the waiting queries as well. Health counts it once for
+// the query that sent the request
+type sharedError struct {
+	err error
+}
+
+func (e *sharedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sharedError) Unwrap() error {
+	return e.err
+}
+
+// Coalesce wraps the source so result pages with the same search url are fetched once within the ttl.
+// Concurrent requests for a page that is being fetched wait for the running request.
+func Coalesce(source Source, ttl time.Duration) Source {
+	return &coalescedSource{
+		Source: source,
+		ttl:    ttl,
+		pages:  make(map[string]*coalescedPage),
+	}
+}
+
+// CoalesceAll wraps all registered sources with Coalesce
+func CoalesceAll(ttl time.Duration) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	for name, source := range sources {
+		if _, ok := source.(*coalescedSource); !ok {
+			sources[name] = Coalesce(source, ttl)
+		}
+	}
+}
+
+// Unwrap returns the wrapped source
+func (c *coalescedSource) Unwrap() Source {
+	return c.Source
+}
+
+// GetAds returns the cached ads of the page or fetches them. Waiting for a running request is cancelled with the context.
+// If the request was cancelled by the context of the query that sent it, the waiting queries fetch the page again
+func (c *coalescedSource) GetAds(ctx context.Context, page int, search Search) ([]Ad, error) {
+	key := c.SearchURL(page, search)
+	now := time.Now()
+
+	c.mu.Lock()
+
+	for k, p := range c.pages {
+		if isDone(p) && now.Sub(p.fetchedAt) >= c.ttl {
+			delete(c.pages, k)
+		}
+	}
+
+	p, ok := c.pages[key]
+
+	if !ok {
+		p = &coalescedPage{done: make(chan struct{})}
+		c.pages[key] = p
+	}
+
+	c.mu.Unlock()
+
+	if ok {
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return nil, contextError(ctx.Err())
+		}
+
+		if p.cancelled && ctx.Err() == nil {
+			return c.GetAds(ctx, page, search)
+		}
+
+		log.Debug().Str("url", key).Msg("using coalesced result page")
+
+		if p.err != nil {
+			return nil, &sharedError{err: p.err}
+		}
+	} else {
+		p.ads, p.err = c.Source.GetAds(ctx, page, search)
+		p.cancelled = ctx.Err() != nil
+		p.fetchedAt = time.Now()
+
+		// errors are not shared with later requests
+		if p.err != nil {
+			c.mu.Lock()
+			delete(c.pages, key)
+			c.mu.Unlock()
+		}
+
+		close(p.done)
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	ads := make([]Ad, len(p.ads))
+	copy(ads, p.ads)
+
+	return ads, nil
+}
+
+func isDone(p *coalescedPage) bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
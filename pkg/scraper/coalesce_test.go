@@ -0,0 +1,161 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalesceSharesPages(t *testing.T) {
+	source := &fakeSource{pages: map[int][]Ad{1: {{ID: "1"}, {ID: "2"}}}, release: make(chan struct{})}
+	c := Coalesce(source, time.Minute)
+	search := Search{Term: "fahrrad"}
+
+	var wg sync.WaitGroup
+	results := make([][]Ad, 5)
+	errs := make([]error, 5)
+
+	for i := range results {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = c.GetAds(context.Background(), 1, search)
+		}(i)
+	}
+
+	waitForRequests(t, source, 1)
+	close(source.release)
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil || len(results[i]) != 2 {
+			t.Errorf("query %d got %v %v, want the shared page", i, results[i], errs[i])
+		}
+	}
+
+	// the page is cached within the ttl, other pages are fetched
+	if _, err := c.GetAds(context.Background(), 1, search); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.GetAds(context.Background(), 2, search); err != nil {
+		t.Fatal(err)
+	}
+
+	if source.Requests() != 2 {
+		t.Errorf("source received %d requests, want 2", source.Requests())
+	}
+}
+
+func TestCoalesceExpiresPages(t *testing.T) {
+	source := &fakeSource{pages: map[int][]Ad{1: {{ID: "1"}}}}
+	c := Coalesce(source, time.Minute).(*coalescedSource)
+	search := Search{Term: "fahrrad"}
+
+	if _, err := c.GetAds(context.Background(), 1, search); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	c.pages[c.SearchURL(1, search)].fetchedAt = time.Now().Add(-time.Minute)
+	c.mu.Unlock()
+
+	if _, err := c.GetAds(context.Background(), 1, search); err != nil {
+		t.Fatal(err)
+	}
+
+	if source.Requests() != 2 {
+		t.Errorf("source received %d requests, want 2 after the ttl", source.Requests())
+	}
+}
+
+func TestCoalesceDoesNotCacheErrors(t *testing.T) {
+	source := &fakeSource{errs: map[int]error{1: ErrBlocked}, release: make(chan struct{})}
+	c := Coalesce(source, time.Minute)
+	search := Search{Term: "fahrrad"}
+	health := NewHealth(10, time.Minute, time.Hour)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+
+	for i := range errs {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = c.GetAds(context.Background(), 1, search)
+		}(i)
+	}
+
+	waitForRequests(t, source, 1)
+	close(source.release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if !errors.Is(err, ErrBlocked) {
+			t.Errorf("query %d: err = %v, want ErrBlocked", i, err)
+		}
+
+		health.Report(err)
+	}
+
+	// the block is counted once per request and not for every waiting query
+	if health.failures != source.Requests() {
+		t.Errorf("%d failures counted for %d requests", health.failures, source.Requests())
+	}
+
+	if _, err := c.GetAds(context.Background(), 1, search); !errors.Is(err, ErrBlocked) {
+		t.Errorf("err = %v, want ErrBlocked", err)
+	}
+
+	if requests := source.Requests(); requests < 2 {
+		t.Errorf("the error was cached: %d requests", requests)
+	}
+}
+
+func TestCoalesceCancelledLeader(t *testing.T) {
+	source := &fakeSource{pages: map[int][]Ad{1: {{ID: "1"}}}, release: make(chan struct{})}
+	c := Coalesce(source, time.Minute)
+	search := Search{Term: "fahrrad"}
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+
+	go func() {
+		_, err := c.GetAds(leaderCtx, 1, search)
+		leaderErr <- err
+	}()
+
+	waitForRequests(t, source, 1)
+
+	type result struct {
+		ads []Ad
+		err error
+	}
+
+	follower := make(chan result, 1)
+
+	go func() {
+		ads, err := c.GetAds(context.Background(), 1, search)
+		follower <- result{ads, err}
+	}()
+
+	// the follower waits for the request of the leader
+	time.Sleep(time.Millisecond * 20)
+	cancel()
+
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader: err = %v, want context.Canceled", err)
+	}
+
+	// the follower fetches the page itself
+	waitForRequests(t, source, 2)
+	close(source.release)
+
+	if r := <-follower; r.err != nil || len(r.ads) != 1 {
+		t.Errorf("follower got %v %v, want the page", r.ads, r.err)
+	}
+}
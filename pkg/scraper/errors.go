@@ -0,0 +1,68 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrBlocked is returned if the site blocks the requests of the bot. This affects all queries and not a single one
+var ErrBlocked = errors.New("blocked by the site")
+
+// ErrRateLimited is returned if the site answers with too many requests. Like ErrBlocked it affects all queries
+var ErrRateLimited = errors.New("rate limited by the site")
+
+// ErrNotFound is returned if the requested page or city does not exist on the site
+var ErrNotFound = errors.New("not found")
+
+// ErrTimeout is returned if the site did not answer in time
+var ErrTimeout = errors.New("request timed out")
+
+// ErrParse is returned if the answer of the site could not be parsed
+var ErrParse = errors.New("could not parse the answer of the site")
+
+// ErrCityAmbiguous is returned if several cities match the searched city
+var ErrCityAmbiguous = errors.New("city is ambiguous")
+
+// ErrInvalidLink is returned if no source accepts a custom link
+var ErrInvalidLink = errors.New("invalid link")
+
+// CityError is returned by FindCity if the city could not be resolved. It wraps ErrNotFound or ErrCityAmbiguous
+type CityError struct {
+	City       string
+	Candidates []string
+	Err        error
+}
+
+func (e *CityError) Error() string {
+	if len(e.Candidates) > 0 {
+		return fmt.Sprintf("city %q: %v (%s)", e.City, e.Err, strings.Join(e.Candidates, ", "))
+	}
+
+	return fmt.Sprintf("city %q: %v", e.City, e.Err)
+}
+
+// Unwrap returns the reason why the city could not be resolved
+func (e *CityError) Unwrap() error {
+	return e.Err
+}
+
+// SiteWide returns true if the error affects all queries of the source and not only the query that ran into it
+func SiteWide(err error) bool {
+	return errors.Is(err, ErrBlocked) || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrLayoutChanged)
+}
+
+// statusError maps an unexpected status code of the site to the matching error
+func statusError(res *Response) error {
+	switch res.StatusCode {
+	case http.StatusForbidden:
+		return ErrBlocked
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusNotFound, http.StatusGone:
+		return ErrNotFound
+	}
+
+	return fmt.Errorf("received status %s", res.Status)
+}
@@ -0,0 +1,114 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current results")
+
+var selectorsFile = flag.String("selectors", "", "json file with css selectors used instead of the built in ones")
+
+// TestGolden checks the parsers against the saved pages in the fixture directory. Every page has a .golden.json file next to it
+// with the expected result. Run it with -selectors to check a new selector file before it is deployed
+func TestGolden(t *testing.T) {
+	// the dates of the pages are parsed in the local time zone. the golden files are written in utc
+	local := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = local }()
+
+	if *selectorsFile != "" {
+		if err := LoadSelectors(*selectorsFile); err != nil {
+			t.Fatalf("could not load selectors: %v", err)
+		}
+
+		defer func() {
+			selectorsMu.Lock()
+			selectors = DefaultSelectors()
+			selectorsMu.Unlock()
+		}()
+	}
+
+	parsers := map[string]func(r io.Reader) (interface{}, error){
+		"results": func(r io.Reader) (interface{}, error) {
+			return ParseAds(r, DefaultBaseURL)
+		},
+		"details": func(r io.Reader) (interface{}, error) {
+			return ParseAdDetails(r)
+		},
+	}
+
+	for kind, parse := range parsers {
+		pages, err := filepath.Glob(filepath.Join("fixtures", kind, "*.html"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(pages) == 0 {
+			t.Fatalf("no %s fixtures found", kind)
+		}
+
+		for _, page := range pages {
+			page, parse := page, parse
+
+			t.Run(kind+"/"+filepath.Base(page), func(t *testing.T) {
+				checkGolden(t, page, parse)
+			})
+		}
+	}
+}
+
+// checkGolden parses the page and compares the result with the golden file
+func checkGolden(t *testing.T, page string, parse func(r io.Reader) (interface{}, error)) {
+	golden := strings.TrimSuffix(page, ".html") + ".golden.json"
+
+	file, err := os.Open(page)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	result, err := parse(file)
+
+	if err != nil {
+		t.Fatalf("could not parse fixture: %v", err)
+	}
+
+	actual, err := json.MarshalIndent(result, "", "  ")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual = append(actual, '\n')
+
+	if *update {
+		if err := ioutil.WriteFile(golden, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Logf("updated %s", golden)
+		return
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+
+	if err != nil {
+		t.Fatalf("could not read golden file. run with -update to create it: %v", err)
+	}
+
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("parsed page differs from %s\nexpected:\n%s\nactual:\n%s", golden, expected, actual)
+	}
+}
@@ -0,0 +1,115 @@
+package scraper
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Health tracks if the site blocks the bot. After threshold consecutive blocked requests the circuit opens and
+// polling is paused. The pause starts with minBackoff and doubles with every further block up to maxBackoff.
+type Health struct {
+	mu         sync.Mutex
+	threshold  int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	backoff    time.Duration
+	failures   int
+	open       bool
+	openUntil  time.Time
+	onChange   func(blocked bool, until time.Time)
+	// now returns the current time. It is replaced in tests
+	now func() time.Time
+}
+
+// NewHealth creates a new closed circuit breaker
+func NewHealth(threshold int, minBackoff time.Duration, maxBackoff time.Duration) *Health {
+	return &Health{
+		threshold:  threshold,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		backoff:    minBackoff,
+		now:        time.Now,
+	}
+}
+
+// OnChange registers a function that is called when the site starts or stops blocking the bot
+func (h *Health) OnChange(fn func(blocked bool, until time.Time)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onChange = fn
+}
+
+// Allow returns false while polling is paused. After the pause requests are allowed again to probe the site
+func (h *Health) Allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return !h.open || h.now().After(h.openUntil)
+}
+
+// Blocked returns true and the end of the pause if the circuit is open
+func (h *Health) Blocked() (bool, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.open, h.openUntil
+}
+
+// Report records the result of a scrape. Only ErrBlocked and ErrRateLimited count as failure, other errors are ignored.
+// Errors of a coalesced request are only counted for the query that sent it
+func (h *Health) Report(err error) {
+	var shared *sharedError
+
+	if err != nil && (!errors.Is(err, ErrBlocked) && !errors.Is(err, ErrRateLimited) || errors.As(err, &shared)) {
+		return
+	}
+
+	h.mu.Lock()
+
+	var notify func(bool, time.Time)
+	var until time.Time
+
+	if err == nil {
+		if h.open {
+			log.Info().Msg("site is reachable again. resuming polling")
+			notify = h.onChange
+		}
+
+		h.open = false
+		h.failures = 0
+		h.backoff = h.minBackoff
+	} else {
+		h.failures++
+
+		// while open only the probes after the pause are counted
+		if h.failures >= h.threshold && (!h.open || h.now().After(h.openUntil)) {
+			if h.open {
+				h.backoff *= 2
+
+				if h.backoff > h.maxBackoff {
+					h.backoff = h.maxBackoff
+				}
+			}
+
+			if !h.open {
+				notify = h.onChange
+			}
+
+			h.open = true
+			h.openUntil = h.now().Add(h.backoff)
+			until = h.openUntil
+
+			log.Warn().Dur("backoff", h.backoff).Time("paused_until", h.openUntil).Msg("site blocks the bot. pausing polling")
+		}
+	}
+
+	h.mu.Unlock()
+
+	if notify != nil {
+		notify(err != nil, until)
+	}
+}
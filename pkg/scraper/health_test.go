@@ -0,0 +1,134 @@
+package scraper
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type healthChange struct {
+	blocked bool
+	until   time.Time
+}
+
+// testHealth returns a health with a clock that only moves with the returned advance func. The changes reported with
+// OnChange are recorded
+func testHealth(threshold int, minBackoff time.Duration, maxBackoff time.Duration) (*Health, func(time.Duration), *[]healthChange) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	changes := make([]healthChange, 0)
+
+	h := NewHealth(threshold, minBackoff, maxBackoff)
+	h.now = func() time.Time { return now }
+	h.OnChange(func(blocked bool, until time.Time) {
+		changes = append(changes, healthChange{blocked: blocked, until: until})
+	})
+
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+	}
+
+	return h, advance, &changes
+}
+
+func TestHealthOpensAfterThreshold(t *testing.T) {
+	h, _, changes := testHealth(3, time.Minute, time.Hour)
+	start := h.now()
+
+	h.Report(ErrBlocked)
+	h.Report(fmt.Errorf("page 1: %w", ErrRateLimited))
+
+	// other errors do not count
+	h.Report(ErrTimeout)
+	h.Report(ErrNotFound)
+
+	if blocked, _ := h.Blocked(); blocked || !h.Allow() {
+		t.Fatal("circuit opened before the threshold")
+	}
+
+	h.Report(ErrBlocked)
+
+	if blocked, until := h.Blocked(); !blocked || !until.Equal(start.Add(time.Minute)) {
+		t.Errorf("blocked = %v until %s, want blocked for the min backoff", blocked, until)
+	}
+
+	if h.Allow() {
+		t.Error("requests are allowed while the circuit is open")
+	}
+
+	if fmt.Sprint(*changes) != fmt.Sprint([]healthChange{{true, start.Add(time.Minute)}}) {
+		t.Errorf("changes = %v, want one block", *changes)
+	}
+}
+
+func TestHealthSuccessResetsFailures(t *testing.T) {
+	h, _, changes := testHealth(3, time.Minute, time.Hour)
+
+	h.Report(ErrBlocked)
+	h.Report(ErrBlocked)
+	h.Report(nil)
+	h.Report(ErrBlocked)
+	h.Report(ErrBlocked)
+
+	if blocked, _ := h.Blocked(); blocked {
+		t.Error("failures before the success were counted")
+	}
+
+	if len(*changes) != 0 {
+		t.Errorf("changes = %v, want none", *changes)
+	}
+}
+
+func TestHealthBackoff(t *testing.T) {
+	h, advance, changes := testHealth(1, time.Minute, time.Minute*5)
+
+	h.Report(ErrBlocked)
+
+	// a blocked request that was sent before the pause does not extend it
+	advance(time.Second * 30)
+	h.Report(ErrBlocked)
+
+	if _, until := h.Blocked(); !until.Equal(h.now().Add(time.Second * 30)) {
+		t.Errorf("pause was extended to %s", until)
+	}
+
+	advance(time.Second * 30)
+
+	if h.Allow() {
+		t.Error("requests are allowed at the end of the pause")
+	}
+
+	// the probe after the pause is blocked again. the pause doubles up to the max backoff
+	for _, want := range []time.Duration{time.Minute * 2, time.Minute * 4, time.Minute * 5, time.Minute * 5} {
+		advance(time.Second)
+
+		if !h.Allow() {
+			t.Fatal("probe after the pause is not allowed")
+		}
+
+		h.Report(ErrBlocked)
+
+		if _, until := h.Blocked(); !until.Equal(h.now().Add(want)) {
+			t.Errorf("paused until %s, want %s", until, h.now().Add(want))
+		}
+
+		advance(want)
+	}
+
+	advance(time.Second)
+	h.Report(nil)
+
+	if blocked, _ := h.Blocked(); blocked || !h.Allow() {
+		t.Error("circuit is still open after a success")
+	}
+
+	if len(*changes) != 2 || !(*changes)[0].blocked || (*changes)[1].blocked {
+		t.Errorf("changes = %v, want one block and one recovery", *changes)
+	}
+
+	// the backoff starts with the min backoff again
+	h.Report(ErrBlocked)
+
+	if _, until := h.Blocked(); !until.Equal(h.now().Add(time.Minute)) {
+		t.Errorf("paused until %s, want the min backoff", until)
+	}
+}
@@ -0,0 +1,178 @@
+package scraper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ErrLayoutChanged is returned if a result page could not be parsed as expected. This usually means the site changed
+// its markup and the selectors have to be updated. Like ErrBlocked it affects all queries
+var ErrLayoutChanged = errors.New("layout of the site changed")
+
+// the price and title checks need a few ads so a single odd listing does not raise an alarm
+const minAdsForLayoutCheck = 3
+
+var resultCountRegex = regexp.MustCompile(`(\d{1,3}(?:\.\d{3})+|\d+)\s+Ergebnis`)
+
+// a page is saved at most once per selector version and dumpInterval. Only the newest maxDumps pages are kept
+const dumpInterval = time.Hour
+const maxDumps = 20
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+var dumpMu sync.Mutex
+var dumpDir = filepath.Join(os.TempDir(), "kleinanzeigen-alert")
+var lastDumps = make(map[string]time.Time)
+
+// SetDumpDir sets the directory the pages are saved to when the layout changed
+func SetDumpDir(dir string) {
+	dumpMu.Lock()
+	defer dumpMu.Unlock()
+
+	dumpDir = dir
+}
+
+// CheckLayout checks if the ads parsed from a result page are plausible. It detects pages without the result container,
+// pages which show a result count but no ads were parsed and pages on which the ads miss their ids, titles or prices
+func CheckLayout(body []byte, ads []Ad) error {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	sel := CurrentSelectors()
+
+	if sel.ResultContainer != "" && doc.Find(sel.ResultContainer).Length() == 0 {
+		return fmt.Errorf("%w: the page has no result container %q", ErrLayoutChanged, sel.ResultContainer)
+	}
+
+	if len(ads) == 0 {
+		if count, ok := resultCount(doc, sel); ok && count > 0 {
+			return fmt.Errorf("%w: the page shows %d results but no ad was parsed", ErrLayoutChanged, count)
+		}
+
+		listed := doc.Find(sel.ResultItem).FilterFunction(func(_ int, e *goquery.Selection) bool {
+			return !e.HasClass(sel.TopAdClass) && e.Find(sel.ResultLink).Length() > 0
+		}).Length()
+
+		if listed > 0 {
+			return fmt.Errorf("%w: %d ads are listed but none has an id", ErrLayoutChanged, listed)
+		}
+
+		return nil
+	}
+
+	if len(ads) < minAdsForLayoutCheck {
+		return nil
+	}
+
+	withTitle, withPrice := 0, 0
+
+	for _, ad := range ads {
+		if strings.TrimSpace(ad.Title) != "" {
+			withTitle++
+		}
+
+		if ad.Price.Raw != "" {
+			withPrice++
+		}
+	}
+
+	if withTitle == 0 {
+		return fmt.Errorf("%w: none of the %d ads has a title", ErrLayoutChanged, len(ads))
+	}
+
+	if withPrice == 0 {
+		return fmt.Errorf("%w: none of the %d ads has a price", ErrLayoutChanged, len(ads))
+	}
+
+	return nil
+}
+
+// resultCount reads the total number of results like "1 - 25 von 1.473 Ergebnissen". ok is false if the page has no result count
+func resultCount(doc *goquery.Document, sel Selectors) (int, bool) {
+	if sel.ResultCount == "" {
+		return 0, false
+	}
+
+	match := resultCountRegex.FindStringSubmatch(doc.Find(sel.ResultCount).First().Text())
+
+	if match == nil {
+		return 0, false
+	}
+
+	count, err := strconv.Atoi(strings.ReplaceAll(match[1], ".", ""))
+
+	if err != nil {
+		return 0, false
+	}
+
+	return count, true
+}
+
+// dumpPage saves the page for debugging and returns the path of the file. The path is empty if a page was already
+// saved for the current selector version within dumpInterval
+func dumpPage(body []byte, page int) (string, error) {
+	version := CurrentSelectors().Version
+
+	dumpMu.Lock()
+	defer dumpMu.Unlock()
+
+	if last, ok := lastDumps[version]; ok && time.Since(last) < dumpInterval {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(dumpDir, 0755); err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("layout-%s-%s-page%d.html", time.Now().Format("20060102-150405.000"), unsafeFileChars.ReplaceAllString(version, "_"), page)
+	path := filepath.Join(dumpDir, name)
+
+	if err := ioutil.WriteFile(path, body, 0644); err != nil {
+		return "", err
+	}
+
+	lastDumps[version] = time.Now()
+
+	if err := pruneDumps(dumpDir, maxDumps); err != nil {
+		return path, err
+	}
+
+	return path, nil
+}
+
+// pruneDumps removes the oldest saved pages so only keep pages are left in the directory
+func pruneDumps(dir string, keep int) error {
+	// the names start with the time they were saved at, so the oldest pages come first
+	pages, err := filepath.Glob(filepath.Join(dir, "layout-*.html"))
+
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(pages)
+
+	for len(pages) > keep {
+		if err := os.Remove(pages[0]); err != nil {
+			return err
+		}
+
+		pages = pages[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const captchaPage = `<html><head><title>kleinanzeigen.de</title></head><body>
+<script src="https://geo.captcha-delivery.com/captcha/?initialCid=abc"></script></body></html>`
+
+const challengePage = `<html><head><title>Just a moment...</title></head><body>
+<div id="cf-challenge-running"></div></body></html>`
+
+const unknownPage = `<html><head><title>Kleinanzeigen</title></head><body><main id="search-results"></main></body></html>`
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	body, err := ioutil.ReadFile(filepath.Join("fixtures", "results", name))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+// useDumpDir saves the pages to a temporary directory and forgets the saved pages for the test
+func useDumpDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	dumpMu.Lock()
+	previous := dumpDir
+	dumpDir = dir
+	lastDumps = make(map[string]time.Time)
+	dumpMu.Unlock()
+
+	t.Cleanup(func() {
+		dumpMu.Lock()
+		dumpDir = previous
+		lastDumps = make(map[string]time.Time)
+		dumpMu.Unlock()
+	})
+
+	return dir
+}
+
+func TestIsBlockedPage(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    []byte
+		blocked bool
+	}{
+		{"result page", readFixture(t, "fahrrad-koeln.html"), false},
+		{"no results", readFixture(t, "no-results.html"), false},
+		{"unknown layout", []byte(unknownPage), false},
+		{"ad mentioning a captcha", []byte(`<div id="srchrslt-content"><a>Captcha Buch</a></div>`), false},
+		{"captcha", []byte(captchaPage), true},
+		{"challenge", []byte(challengePage), true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isBlockedPage(c.body); got != c.blocked {
+				t.Errorf("isBlockedPage = %v, want %v", got, c.blocked)
+			}
+		})
+	}
+}
+
+func TestCheckLayout(t *testing.T) {
+	parse := func(body []byte) []Ad {
+		ads, err := ParseAds(bytes.NewReader(body), DefaultBaseURL)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ads
+	}
+
+	results := readFixture(t, "fahrrad-koeln.html")
+	empty := readFixture(t, "no-results.html")
+
+	if err := CheckLayout(results, parse(results)); err != nil {
+		t.Errorf("result page: %v", err)
+	}
+
+	if err := CheckLayout(empty, parse(empty)); err != nil {
+		t.Errorf("page without results: %v", err)
+	}
+
+	if err := CheckLayout([]byte(unknownPage), nil); !errors.Is(err, ErrLayoutChanged) {
+		t.Errorf("page without result container: err = %v, want ErrLayoutChanged", err)
+	}
+
+	if err := CheckLayout(results, nil); !errors.Is(err, ErrLayoutChanged) {
+		t.Errorf("result page without parsed ads: err = %v, want ErrLayoutChanged", err)
+	}
+}
+
+func TestGetAdsClassifiesPages(t *testing.T) {
+	useDumpDir(t)
+
+	var mu sync.Mutex
+	body := []byte(captchaPage)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	client := HTTPClient()
+	SetClient(NewClient(1000, 1000, 1, time.Second*5, "scraper-test"))
+	defer SetClient(client)
+
+	k := NewKleinanzeigen(server.URL)
+	search := Search{Term: "fahrrad", CityCode: 945, Radius: 10}
+
+	if _, err := k.GetAds(context.Background(), 1, search); !errors.Is(err, ErrBlocked) {
+		t.Errorf("captcha page: err = %v, want ErrBlocked", err)
+	}
+
+	mu.Lock()
+	body = []byte(unknownPage)
+	mu.Unlock()
+
+	if _, err := k.GetAds(context.Background(), 1, search); !errors.Is(err, ErrLayoutChanged) {
+		t.Errorf("unknown layout: err = %v, want ErrLayoutChanged", err)
+	}
+}
+
+func TestDumpPageThrottled(t *testing.T) {
+	dir := useDumpDir(t)
+
+	path, err := dumpPage([]byte(unknownPage), 1)
+
+	if err != nil || path == "" {
+		t.Fatalf("first page was not saved: %q %v", path, err)
+	}
+
+	path, err = dumpPage([]byte(unknownPage), 2)
+
+	if err != nil || path != "" {
+		t.Errorf("second page within the interval was saved: %q %v", path, err)
+	}
+
+	// the interval has passed
+	dumpMu.Lock()
+	lastDumps[CurrentSelectors().Version] = time.Now().Add(-dumpInterval)
+	dumpMu.Unlock()
+
+	if path, err := dumpPage([]byte(unknownPage), 3); err != nil || path == "" {
+		t.Errorf("page after the interval was not saved: %q %v", path, err)
+	}
+
+	pages, _ := filepath.Glob(filepath.Join(dir, "layout-*.html"))
+
+	if len(pages) != 2 {
+		t.Errorf("%d pages saved, want 2", len(pages))
+	}
+}
+
+func TestPruneDumps(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"layout-20240101-100000.000-2024-02-page1.html",
+		"layout-20240102-100000.000-2024-02-page1.html",
+		"layout-20240103-100000.000-2024-03-page1.html",
+		"notes.txt",
+	}
+
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneDumps(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	left := make([]string, 0, len(files))
+
+	for _, f := range files {
+		left = append(left, f.Name())
+	}
+
+	want := []string{names[1], names[2], names[3]}
+
+	if fmt.Sprint(left) != fmt.Sprint(want) {
+		t.Errorf("files left %v, want %v", left, want)
+	}
+}
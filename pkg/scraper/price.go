@@ -0,0 +1,122 @@
+package scraper
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// amountRegex matches german formatted amounts like "1.234,50", amounts with a decimal point like "12.50" and
+// amounts in millions like "1,5 Mio"
+var amountRegex = regexp.MustCompile(`(?i)(\d{1,3}(?:\.\d{3})+(?:,\d{1,2})?|\d+\.\d{1,2}|\d+(?:,\d{1,2})?)(\s*mio\b)?`)
+
+var decimalPointRegex = regexp.MustCompile(`^\d+\.\d{1,2}$`)
+
+var shippingRegex = regexp.MustCompile(`(?i)\+?\s*versand[^0-9]*`)
+
+// Price is the parsed price of an ad. All amounts are in cents
+type Price struct {
+	Raw        string
+	Cents      *int64
+	MaxCents   *int64
+	Shipping   *int64
+	Negotiable bool
+	Free       bool
+	OnRequest  bool
+}
+
+// ParsePrice parses the price text of an ad like "1.234 € VB", "Zu verschenken", "100 - 200 €" or "15 € + Versand ab 4,89 €"
+func ParsePrice(raw string) Price {
+	text := strings.TrimSpace(whitespace.ReplaceAllString(raw, " "))
+	p := Price{Raw: text}
+	lower := strings.ToLower(text)
+
+	if strings.Contains(lower, "zu verschenken") {
+		p.Free = true
+		zero := int64(0)
+		p.Cents = &zero
+		return p
+	}
+
+	p.OnRequest = strings.Contains(lower, "auf anfrage")
+	p.Negotiable = strings.Contains(lower, "vb") || strings.Contains(lower, "verhandlungsbasis")
+
+	if loc := shippingRegex.FindStringIndex(text); loc != nil {
+		if amount := amountRegex.FindStringSubmatch(text[loc[1]:]); amount != nil {
+			p.Shipping = parseCents(amount[1], amount[2] != "")
+		}
+
+		text = text[:loc[0]]
+	}
+
+	amounts := amountRegex.FindAllStringSubmatch(text, 2)
+
+	if len(amounts) > 0 {
+		p.Cents = parseCents(amounts[0][1], amounts[0][2] != "")
+	}
+
+	if len(amounts) > 1 && strings.Contains(text, "-") {
+		p.MaxCents = parseCents(amounts[1][1], amounts[1][2] != "")
+	}
+
+	return p
+}
+
+// HasAmount returns true if the price contains an amount
+func (p Price) HasAmount() bool {
+	return p.Cents != nil
+}
+
+// Upper returns the upper bound of a price range or the amount if the price is not a range
+func (p Price) Upper() *int64 {
+	if p.MaxCents != nil {
+		return p.MaxCents
+	}
+
+	return p.Cents
+}
+
+// String returns the price as it was shown on the site
+func (p Price) String() string {
+	return p.Raw
+}
+
+// parseCents parses german formatted amounts like "1.234,50" and amounts with a decimal point like "12.50".
+// The amount is multiplied by a million if millions is true
+func parseCents(amount string, millions bool) *int64 {
+	if decimalPointRegex.MatchString(amount) {
+		amount = strings.Replace(amount, ".", ",", 1)
+	}
+
+	parts := strings.SplitN(strings.ReplaceAll(amount, ".", ""), ",", 2)
+
+	euros, err := strconv.ParseInt(parts[0], 10, 64)
+
+	if err != nil {
+		return nil
+	}
+
+	cents := euros * 100
+
+	if len(parts) == 2 {
+		fraction := parts[1]
+
+		if len(fraction) == 1 {
+			fraction += "0"
+		}
+
+		c, err := strconv.ParseInt(fraction, 10, 64)
+
+		if err != nil {
+			return nil
+		}
+
+		cents += c
+	}
+
+	if millions {
+		cents *= 1000000
+	}
+
+	return &cents
+}
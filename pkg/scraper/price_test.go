@@ -0,0 +1,72 @@
+package scraper
+
+import (
+	"fmt"
+	"testing"
+)
+
+func cents(amount int64) *int64 {
+	return &amount
+}
+
+func formatCents(amount *int64) string {
+	if amount == nil {
+		return "nil"
+	}
+
+	return fmt.Sprint(*amount)
+}
+
+func TestParsePrice(t *testing.T) {
+	cases := []struct {
+		raw        string
+		cents      *int64
+		maxCents   *int64
+		shipping   *int64
+		negotiable bool
+		free       bool
+		onRequest  bool
+	}{
+		{raw: "50 €", cents: cents(5000)},
+		{raw: "1.234 € VB", cents: cents(123400), negotiable: true},
+		{raw: "VB", negotiable: true},
+		{raw: "Verhandlungsbasis", negotiable: true},
+		{raw: "Zu verschenken", cents: cents(0), free: true},
+		{raw: "12.500 €", cents: cents(1250000)},
+		{raw: "1.234.567 €", cents: cents(123456700)},
+		{raw: "1.234,50 €", cents: cents(123450)},
+		{raw: "12,50 €", cents: cents(1250)},
+		{raw: "12,5 €", cents: cents(1250)},
+		{raw: "12.50 €", cents: cents(1250)},
+		{raw: "12.5 €", cents: cents(1250)},
+		{raw: "1,5 Mio €", cents: cents(150000000)},
+		{raw: "2 Mio. € VB", cents: cents(200000000), negotiable: true},
+		{raw: "100 - 200 €", cents: cents(10000), maxCents: cents(20000)},
+		{raw: "15 € + Versand ab 4,89 €", cents: cents(1500), shipping: cents(489)},
+		{raw: "Preis auf Anfrage", onRequest: true},
+		{raw: ""},
+		{raw: "kostenlos abzuholen"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.raw, func(t *testing.T) {
+			p := ParsePrice(c.raw)
+
+			if formatCents(p.Cents) != formatCents(c.cents) {
+				t.Errorf("cents = %s, want %s", formatCents(p.Cents), formatCents(c.cents))
+			}
+
+			if formatCents(p.MaxCents) != formatCents(c.maxCents) {
+				t.Errorf("max cents = %s, want %s", formatCents(p.MaxCents), formatCents(c.maxCents))
+			}
+
+			if formatCents(p.Shipping) != formatCents(c.shipping) {
+				t.Errorf("shipping = %s, want %s", formatCents(p.Shipping), formatCents(c.shipping))
+			}
+
+			if p.Negotiable != c.negotiable || p.Free != c.free || p.OnRequest != c.onRequest {
+				t.Errorf("negotiable, free, on request = %v %v %v, want %v %v %v", p.Negotiable, p.Free, p.OnRequest, c.negotiable, c.free, c.onRequest)
+			}
+		})
+	}
+}
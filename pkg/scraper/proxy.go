@@ -0,0 +1,181 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ProxyPool rotates the requests across proxies. A proxy that is blocked or times out is skipped for the cooldown
+type ProxyPool struct {
+	mu       sync.Mutex
+	proxies  []*proxy
+	next     int
+	cooldown time.Duration
+}
+
+type proxy struct {
+	url            *neturl.URL
+	transport      *http.Transport
+	successes      int
+	failures       int
+	lastError      string
+	unhealthyUntil time.Time
+}
+
+// ProxyStats are the statistics of a single proxy
+type ProxyStats struct {
+	URL            string
+	Successes      int
+	Failures       int
+	LastError      string
+	Healthy        bool
+	UnhealthyUntil time.Time
+}
+
+func newProxyPool(proxyURLs []string, cooldown time.Duration, concurrency int, timeout time.Duration) (*ProxyPool, error) {
+	if len(proxyURLs) == 0 {
+		return nil, errors.New("no proxies given")
+	}
+
+	pool := &ProxyPool{cooldown: cooldown}
+
+	for _, raw := range proxyURLs {
+		u, err := neturl.Parse(strings.TrimSpace(raw))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", raw, err)
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+			return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+
+		pool.proxies = append(pool.proxies, &proxy{
+			url:       u,
+			transport: newTransport(http.ProxyURL(u), concurrency, timeout),
+		})
+	}
+
+	log.Info().Int("number_of_proxies", len(pool.proxies)).Msg("using proxies for scraping")
+
+	return pool, nil
+}
+
+// RoundTrip sends the request through the next healthy proxy. Idempotent requests are retried on the next healthy proxy
+// if a proxy is blocked or times out. Requests cancelled by the caller or past the deadline of the caller do not count
+// as failure of the proxy
+func (p *ProxyPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	var res *http.Response
+	var err error
+
+	for attempt := 0; attempt < len(p.proxies); attempt++ {
+		px := p.pick()
+
+		if px == nil {
+			if attempt == 0 {
+				return nil, fmt.Errorf("no healthy proxy: %w", ErrBlocked)
+			}
+
+			// the failed response of the last proxy is returned
+			return res, err
+		}
+
+		if res != nil {
+			res.Body.Close()
+		}
+
+		res, err = px.transport.RoundTrip(req)
+
+		switch {
+		case errors.Is(err, context.Canceled) || req.Context().Err() != nil:
+			return res, err
+		case err != nil:
+			p.failed(px, err.Error())
+		case res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusTooManyRequests:
+			p.failed(px, res.Status)
+		default:
+			p.succeeded(px)
+			return res, nil
+		}
+
+		if req.Context().Err() != nil || !retryable(req) {
+			return res, err
+		}
+
+		log.Debug().Str("proxy", px.url.Redacted()).Str("url", req.URL.Redacted()).Msg("retrying request on the next proxy")
+	}
+
+	return res, err
+}
+
+// retryable reports if the request can be sent again without side effects
+func retryable(req *http.Request) bool {
+	return (req.Method == http.MethodGet || req.Method == http.MethodHead) && (req.Body == nil || req.Body == http.NoBody)
+}
+
+// Stats returns the statistics of all proxies
+func (p *ProxyPool) Stats() []ProxyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]ProxyStats, 0, len(p.proxies))
+
+	for _, px := range p.proxies {
+		stats = append(stats, ProxyStats{
+			URL:            px.url.Redacted(),
+			Successes:      px.successes,
+			Failures:       px.failures,
+			LastError:      px.lastError,
+			Healthy:        !now.Before(px.unhealthyUntil),
+			UnhealthyUntil: px.unhealthyUntil,
+		})
+	}
+
+	return stats
+}
+
+// pick returns the next healthy proxy in round robin order
+func (p *ProxyPool) pick() *proxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	for i := 0; i < len(p.proxies); i++ {
+		px := p.proxies[(p.next+i)%len(p.proxies)]
+
+		if !now.Before(px.unhealthyUntil) {
+			p.next = (p.next + i + 1) % len(p.proxies)
+			return px
+		}
+	}
+
+	return nil
+}
+
+func (p *ProxyPool) failed(px *proxy, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	px.failures++
+	px.lastError = reason
+	px.unhealthyUntil = time.Now().Add(p.cooldown)
+
+	log.Warn().Str("proxy", px.url.Redacted()).Str("reason", reason).Dur("cooldown", p.cooldown).Msg("proxy marked as unhealthy")
+}
+
+func (p *ProxyPool) succeeded(px *proxy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	px.successes++
+}
@@ -0,0 +1,268 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// standInProxy is an http server acting as proxy. Plain http requests are sent to the proxy with the full url, so
+// the server answers them like the target site would
+type standInProxy struct {
+	*httptest.Server
+	requests int32
+}
+
+func newStandInProxy(t *testing.T, handler http.HandlerFunc) *standInProxy {
+	t.Helper()
+
+	p := &standInProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&p.requests, 1)
+		handler(w, r)
+	}))
+
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func (p *standInProxy) Requests() int {
+	return int(atomic.LoadInt32(&p.requests))
+}
+
+func answer(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func proxyClient(t *testing.T, timeout time.Duration, proxies ...*standInProxy) *Client {
+	t.Helper()
+
+	urls := make([]string, 0, len(proxies))
+
+	for _, p := range proxies {
+		urls = append(urls, p.URL)
+	}
+
+	client := NewClient(0, 1, 4, timeout, "proxy-test")
+
+	if err := client.UseProxies(urls, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func statsOf(client *Client) map[string]ProxyStats {
+	stats := make(map[string]ProxyStats)
+
+	for _, s := range client.ProxyStats() {
+		stats[s.URL] = s
+	}
+
+	return stats
+}
+
+func TestProxyPoolRotates(t *testing.T) {
+	first := newStandInProxy(t, answer(http.StatusOK, "first"))
+	second := newStandInProxy(t, answer(http.StatusOK, "second"))
+	client := proxyClient(t, time.Second*5, first, second)
+
+	for i := 0; i < 4; i++ {
+		if _, err := client.Get(context.Background(), "http://site.test/", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if first.Requests() != 2 || second.Requests() != 2 {
+		t.Errorf("requests = %d and %d, want 2 per proxy", first.Requests(), second.Requests())
+	}
+}
+
+func TestProxyPoolRetriesBlockedProxy(t *testing.T) {
+	for _, status := range []int{http.StatusForbidden, http.StatusTooManyRequests} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			blocked := newStandInProxy(t, answer(status, "blocked"))
+			healthy := newStandInProxy(t, answer(http.StatusOK, "ok"))
+			client := proxyClient(t, time.Second*5, blocked, healthy)
+
+			res, err := client.Get(context.Background(), "http://site.test/", nil)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != http.StatusOK || string(res.Body) != "ok" {
+				t.Errorf("response = %d %q, want the response of the healthy proxy", res.StatusCode, res.Body)
+			}
+
+			stats := statsOf(client)
+
+			if s := stats[blocked.URL]; s.Failures != 1 || s.Healthy {
+				t.Errorf("blocked proxy = %+v, want one failure and unhealthy", s)
+			}
+
+			if s := stats[healthy.URL]; s.Successes != 1 || !s.Healthy {
+				t.Errorf("healthy proxy = %+v, want one success", s)
+			}
+
+			// the blocked proxy is skipped for the cooldown
+			if _, err := client.Get(context.Background(), "http://site.test/", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if blocked.Requests() != 1 {
+				t.Errorf("blocked proxy got %d requests, want 1", blocked.Requests())
+			}
+		})
+	}
+}
+
+func TestProxyPoolRetriesTimedOutProxy(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	hanging := newStandInProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	healthy := newStandInProxy(t, answer(http.StatusOK, "ok"))
+	client := proxyClient(t, time.Millisecond*200, hanging, healthy)
+
+	res, err := client.Get(context.Background(), "http://site.test/", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(res.Body) != "ok" {
+		t.Errorf("body = %q, want the response of the healthy proxy", res.Body)
+	}
+
+	if s := statsOf(client)[hanging.URL]; s.Failures != 1 || s.Healthy {
+		t.Errorf("hanging proxy = %+v, want one failure and unhealthy", s)
+	}
+}
+
+func TestProxyPoolAllProxiesBlocked(t *testing.T) {
+	first := newStandInProxy(t, answer(http.StatusTooManyRequests, "slow down"))
+	second := newStandInProxy(t, answer(http.StatusForbidden, "blocked"))
+	client := proxyClient(t, time.Second*5, first, second)
+
+	res, err := client.Get(context.Background(), "http://site.test/", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the response of the last proxy is returned, so the caller sees the block
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusForbidden)
+	}
+
+	if _, err := client.Get(context.Background(), "http://site.test/", nil); !errors.Is(err, ErrBlocked) {
+		t.Errorf("err = %v, want ErrBlocked", err)
+	}
+}
+
+func TestProxyPoolIgnoresCancelledRequests(t *testing.T) {
+	started := make(chan struct{})
+
+	hanging := newStandInProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+	healthy := newStandInProxy(t, answer(http.StatusOK, "ok"))
+	client := proxyClient(t, time.Second*5, hanging, healthy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	if _, err := client.Get(ctx, "http://site.test/", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+
+	stats := statsOf(client)
+
+	if s := stats[hanging.URL]; s.Failures != 0 || !s.Healthy {
+		t.Errorf("cancelled proxy = %+v, want no failure", s)
+	}
+
+	if healthy.Requests() != 0 {
+		t.Errorf("cancelled request was retried on the next proxy")
+	}
+}
+
+func TestProxyPoolDoesNotRetryPost(t *testing.T) {
+	blocked := newStandInProxy(t, answer(http.StatusTooManyRequests, "slow down"))
+	healthy := newStandInProxy(t, answer(http.StatusOK, "ok"))
+	client := proxyClient(t, time.Second*5, blocked, healthy)
+
+	req, err := http.NewRequest(http.MethodPost, "http://site.test/", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.proxies.RoundTrip(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusTooManyRequests || healthy.Requests() != 0 {
+		t.Errorf("post was retried: status %d, %d requests to the next proxy", res.StatusCode, healthy.Requests())
+	}
+}
+
+func TestProxyPoolIgnoresCallerDeadline(t *testing.T) {
+	hanging := newStandInProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	client := proxyClient(t, time.Second*5, hanging)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if _, err := client.Get(ctx, "http://site.test/", nil); !errors.Is(err, ErrTimeout) {
+		t.Errorf("err = %v, want ErrTimeout", err)
+	}
+
+	if s := statsOf(client)[hanging.URL]; s.Failures != 0 || !s.Healthy {
+		t.Errorf("proxy = %+v, want no failure after the deadline of the caller", s)
+	}
+}
+
+func TestProxyClientTimesOutReadingBody(t *testing.T) {
+	slow := newStandInProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("start"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	client := proxyClient(t, time.Millisecond*200, slow)
+	start := time.Now()
+
+	if _, err := client.Get(context.Background(), "http://site.test/", nil); !errors.Is(err, ErrTimeout) {
+		t.Errorf("err = %v, want ErrTimeout", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("reading the body took %s, want the timeout of the client", elapsed)
+	}
+}
@@ -0,0 +1,98 @@
+package scraper
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed selectors.json
+var defaultSelectors []byte
+
+// Selectors are the css selectors used to parse the pages of kleinanzeigen. They are versioned so a changed layout
+// can be fixed by loading a new selector file without a rebuild.
+type Selectors struct {
+	Version string `json:"version"`
+
+	ResultContainer   string `json:"result_container"`
+	ResultItem        string `json:"result_item"`
+	TopAdClass        string `json:"top_ad_class"`
+	ResultLink        string `json:"result_link"`
+	ResultPrice       string `json:"result_price"`
+	ResultLocation    string `json:"result_location"`
+	ResultID          string `json:"result_id"`
+	ResultIDAttribute string `json:"result_id_attribute"`
+	ResultCount       string `json:"result_count"`
+
+	DetailTitle          string `json:"detail_title"`
+	DetailID             string `json:"detail_id"`
+	DetailPrice          string `json:"detail_price"`
+	DetailLocation       string `json:"detail_location"`
+	DetailDescription    string `json:"detail_description"`
+	DetailShipping       string `json:"detail_shipping"`
+	DetailImages         string `json:"detail_images"`
+	DetailContact        string `json:"detail_contact"`
+	DetailSellerName     string `json:"detail_seller_name"`
+	DetailSellerDetails  string `json:"detail_seller_details"`
+	DetailPostedAt       string `json:"detail_posted_at"`
+	DetailAttributes     string `json:"detail_attributes"`
+	DetailAttributeValue string `json:"detail_attribute_value"`
+}
+
+var selectorsMu sync.RWMutex
+var selectors = mustParseSelectors(defaultSelectors)
+
+func mustParseSelectors(data []byte) Selectors {
+	var sel Selectors
+
+	if err := json.Unmarshal(data, &sel); err != nil {
+		panic(fmt.Sprintf("invalid default selectors: %v", err))
+	}
+
+	return sel
+}
+
+// DefaultSelectors returns the selectors built into the binary
+func DefaultSelectors() Selectors {
+	return mustParseSelectors(defaultSelectors)
+}
+
+// CurrentSelectors returns the selectors used for parsing
+func CurrentSelectors() Selectors {
+	selectorsMu.RLock()
+	defer selectorsMu.RUnlock()
+
+	return selectors
+}
+
+// LoadSelectors reads a selector file. Selectors missing in the file keep their default value
+func LoadSelectors(path string) error {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	sel := DefaultSelectors()
+
+	if err := json.Unmarshal(data, &sel); err != nil {
+		return fmt.Errorf("could not parse selectors: %w", err)
+	}
+
+	if sel.ResultItem == "" || sel.ResultLink == "" || sel.ResultID == "" || sel.ResultIDAttribute == "" {
+		return errors.New("selectors for the result items must not be empty")
+	}
+
+	selectorsMu.Lock()
+	selectors = sel
+	selectorsMu.Unlock()
+
+	log.Info().Str("path", path).Str("selectors_version", sel.Version).Msg("loaded selectors")
+
+	return nil
+}
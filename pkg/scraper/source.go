@@ -0,0 +1,194 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultSource is the name of the source used for queries without a source
+const DefaultSource = "kleinanzeigen"
+
+// Ad is a representation of the scraped ads
+type Ad struct {
+	Title    string
+	Link     string
+	Price    Price
+	Location string
+	ID       string
+	// Details of the ad. nil if the detail page was not fetched
+	Details *AdDetails
+}
+
+// Search holds the parameters of a search on a source
+type Search struct {
+	Term       string
+	CityCode   int
+	Radius     int
+	CustomLink *string
+}
+
+// Source is a classifieds marketplace ads can be scraped from
+type Source interface {
+	// Name returns the unique name the source is stored with
+	Name() string
+	// SearchURL returns the normalized url of the given result page. Searches with the same url return the same ads
+	SearchURL(page int, search Search) string
+	// GetAds gets the ads on the given result page of the search
+	GetAds(ctx context.Context, page int, search Search) ([]Ad, error)
+	// FindCity resolves a city name or postal code to the city id and name used by the source
+	FindCity(ctx context.Context, city string) (int, string, error)
+	// CheckLink checks if a custom link can be scraped by the source
+	CheckLink(link string) bool
+}
+
+// DetailSource is a source that can fetch the detail page of an ad
+type DetailSource interface {
+	Source
+	// GetAdDetails fetches the detail page of the ad with the given link
+	GetAdDetails(ctx context.Context, link string) (*AdDetails, error)
+}
+
+var sourcesMu sync.RWMutex
+var sources = make(map[string]Source)
+
+// Register makes a source available by its name. Registering a source with the same name replaces the previous one
+func Register(source Source) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	sources[source.Name()] = source
+}
+
+// GetSource returns the source with the given name. An empty name returns the default source
+func GetSource(name string) (Source, error) {
+	if name == "" {
+		name = DefaultSource
+	}
+
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+
+	source, ok := sources[name]
+
+	if !ok {
+		log.Error().Str("source", name).Msg("source is not registered")
+		return nil, errors.New("unknown source")
+	}
+
+	return source, nil
+}
+
+// SourceForLink returns the first source that accepts the given custom link
+func SourceForLink(link string) (Source, error) {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if sources[name].CheckLink(link) {
+			return sources[name], nil
+		}
+	}
+
+	return nil, ErrInvalidLink
+}
+
+// GetAdsUntil walks the result pages starting with the first one and collects the ads until an ad is reached for which known returns true
+// or maxPages pages have been scraped. The ads are returned newest first and do not contain the known ad.
+// known is called for the remaining ads of that page as well, so the caller sees every known ad that is still listed.
+// If a later page fails the ads of the previous pages are returned, unless the error affects the whole site.
+func GetAdsUntil(ctx context.Context, source Source, maxPages int, known func(ad Ad) bool, search Search) ([]Ad, error) {
+	ads := make([]Ad, 0, 0)
+	seen := make(map[string]bool)
+
+	for page := 1; page <= maxPages; page++ {
+		pageAds, err := source.GetAds(ctx, page, search)
+
+		if err != nil {
+			// errors of the whole site are returned so the caller can pause polling. The ads are fetched again with the next run
+			if page == 1 || ctx.Err() != nil || SiteWide(err) || errors.Is(err, ErrTimeout) {
+				return nil, err
+			}
+
+			log.Warn().Err(err).Int("page", page).Msg("could not scrape page. returning ads of the previous pages")
+			return ads, nil
+		}
+
+		// sites usually serve the last page again if the requested page is out of range
+		foundNew := false
+		reached := false
+
+		for _, ad := range pageAds {
+			if reached {
+				known(ad)
+				continue
+			}
+
+			if known(ad) {
+				log.Debug().Int("page", page).Str("ad_id", ad.ID).Msg("reached known ad")
+				reached = true
+				continue
+			}
+
+			if seen[ad.ID] {
+				continue
+			}
+
+			seen[ad.ID] = true
+			foundNew = true
+			ads = append(ads, ad)
+		}
+
+		if reached {
+			return ads, nil
+		}
+
+		if !foundNew {
+			break
+		}
+	}
+
+	return ads, nil
+}
+
+// FetchDetails fetches the detail pages of the ads if the source supports it. Ads whose details could not be fetched are returned without them.
+// The remaining ads are returned without details when the context is done
+func FetchDetails(ctx context.Context, source Source, ads []Ad) []Ad {
+	if wrapper, ok := source.(interface{ Unwrap() Source }); ok {
+		source = wrapper.Unwrap()
+	}
+
+	detailSource, ok := source.(DetailSource)
+
+	if !ok {
+		log.Debug().Str("source", source.Name()).Msg("source does not support ad details")
+		return ads
+	}
+
+	for i := range ads {
+		if ctx.Err() != nil {
+			log.Warn().Err(ctx.Err()).Int("missing_details", len(ads)-i).Msg("stopped fetching ad details")
+			break
+		}
+
+		details, err := detailSource.GetAdDetails(ctx, ads[i].Link)
+
+		if err != nil {
+			log.Warn().Err(err).Str("ad_id", ads[i].ID).Msg("could not fetch ad details")
+			continue
+		}
+
+		ads[i].Details = details
+	}
+
+	return ads
+}
@@ -0,0 +1,149 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSource returns the configured ads and errors per result page and counts the requests. If release is set the
+// requests wait until it is closed or their context is done
+type fakeSource struct {
+	mu       sync.Mutex
+	requests int
+	pages    map[int][]Ad
+	errs     map[int]error
+	release  chan struct{}
+}
+
+func (s *fakeSource) Name() string {
+	return "fake"
+}
+
+func (s *fakeSource) SearchURL(page int, search Search) string {
+	return fmt.Sprintf("https://fake.test/s-%s/seite:%d", search.Term, page)
+}
+
+func (s *fakeSource) GetAds(ctx context.Context, page int, search Search) ([]Ad, error) {
+	s.mu.Lock()
+	s.requests++
+	release := s.release
+	s.mu.Unlock()
+
+	if release != nil {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errs[page]; err != nil {
+		return nil, err
+	}
+
+	return s.pages[page], nil
+}
+
+func (s *fakeSource) FindCity(ctx context.Context, city string) (int, string, error) {
+	return 0, "", ErrNotFound
+}
+
+func (s *fakeSource) CheckLink(link string) bool {
+	return false
+}
+
+func (s *fakeSource) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests
+}
+
+// waitForRequests waits until the source received n requests
+func waitForRequests(t *testing.T, s *fakeSource, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+
+	for s.Requests() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("source received %d requests, want %d", s.Requests(), n)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetAdsUntilPageFails(t *testing.T) {
+	first := []Ad{{ID: "3"}, {ID: "2"}}
+	known := func(ad Ad) bool { return false }
+
+	cases := []struct {
+		name string
+		err  error
+		ads  int
+	}{
+		{"single page", errors.New("received status 500 Internal Server Error"), len(first)},
+		{"parse error", ErrParse, len(first)},
+		{"blocked", ErrBlocked, 0},
+		{"rate limited", ErrRateLimited, 0},
+		{"layout changed", ErrLayoutChanged, 0},
+		{"timeout", ErrTimeout, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			source := &fakeSource{pages: map[int][]Ad{1: first}, errs: map[int]error{2: c.err}}
+			ads, err := GetAdsUntil(context.Background(), source, 5, known, Search{Term: "fahrrad"})
+
+			if c.ads == 0 && !errors.Is(err, c.err) {
+				t.Errorf("err = %v, want %v", err, c.err)
+			}
+
+			if c.ads > 0 && err != nil {
+				t.Errorf("err = %v, want the ads of the first page", err)
+			}
+
+			if len(ads) != c.ads {
+				t.Errorf("got %d ads, want %d", len(ads), c.ads)
+			}
+		})
+	}
+}
+
+func TestGetAdsUntilSeesKnownAdsOfLastPage(t *testing.T) {
+	source := &fakeSource{pages: map[int][]Ad{
+		1: {{ID: "5"}, {ID: "4"}},
+		2: {{ID: "3"}, {ID: "2"}, {ID: "1"}},
+		3: {{ID: "0"}},
+	}}
+
+	var checked []string
+
+	known := func(ad Ad) bool {
+		checked = append(checked, ad.ID)
+		return ad.ID <= "3"
+	}
+
+	ads, err := GetAdsUntil(context.Background(), source, 5, known, Search{Term: "fahrrad"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(ads) != fmt.Sprint([]Ad{{ID: "5"}, {ID: "4"}}) {
+		t.Errorf("got ads %v, want 5 and 4", ads)
+	}
+
+	// the page after the known ad is not scraped, the rest of its page is checked
+	if fmt.Sprint(checked) != "[5 4 3 2 1]" {
+		t.Errorf("checked ads %v, want 5 to 1", checked)
+	}
+}
@@ -0,0 +1,367 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+)
+
+// Memory is a Store that keeps everything in memory. It is used for tests and runs without a database.
+// The methods do not block, so the context is only checked by the methods that return an error
+type Memory struct {
+	mu       sync.Mutex
+	nextID   uint
+	queries  map[uint]model.Query
+	nextAdID uint
+	ads      []model.Ad
+	settings map[string]string
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		nextID:   1,
+		nextAdID: 1,
+		queries:  make(map[uint]model.Query),
+		ads:      make([]model.Ad, 0, 0),
+		settings: make(map[string]string),
+	}
+}
+
+// CreateQuery stores a new query and sets its id
+func (m *Memory) CreateQuery(ctx context.Context, q *model.Query) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	q.ID = m.nextID
+	q.CreatedAt = now
+	q.UpdatedAt = now
+	m.nextID++
+
+	m.queries[q.ID] = *q
+
+	return nil
+}
+
+// SaveQuery saves the changed fields of a query. Unknown queries are created
+func (m *Memory) SaveQuery(ctx context.Context, q *model.Query) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if q.ID == 0 {
+		return m.CreateQuery(ctx, q)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q.UpdatedAt = time.Now()
+	m.queries[q.ID] = *q
+
+	return nil
+}
+
+// FindQueryByID find a query by the given id
+func (m *Memory) FindQueryByID(ctx context.Context, id uint) *model.Query {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queries[id]
+
+	if !ok {
+		return nil
+	}
+
+	return &q
+}
+
+// GetQueries returns all queries ordered by id
+func (m *Memory) GetQueries(ctx context.Context) []model.Query {
+	return m.filterQueries(func(model.Query) bool { return true })
+}
+
+// ListForChatID returns the queries of the chat ordered by id
+func (m *Memory) ListForChatID(ctx context.Context, chatID int64) []model.Query {
+	return m.filterQueries(func(q model.Query) bool { return q.ChatID == chatID })
+}
+
+// GetUniqueChatIDs returns the chats that have queries
+func (m *Memory) GetUniqueChatIDs(ctx context.Context) []int64 {
+	chatIDs := make([]int64, 0, 0)
+	seen := make(map[int64]bool)
+
+	for _, q := range m.GetQueries(ctx) {
+		if !seen[q.ChatID] {
+			seen[q.ChatID] = true
+			chatIDs = append(chatIDs, q.ChatID)
+		}
+	}
+
+	return chatIDs
+}
+
+// RemoveByID removes the query if it belongs to the chat
+func (m *Memory) RemoveByID(ctx context.Context, id uint, chatID int64) *model.Query {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queries[id]
+
+	if !ok || q.ChatID != chatID {
+		return nil
+	}
+
+	m.deleteQuery(id)
+
+	return &q
+}
+
+// RemoveByChatID removes all queries for a chat id
+func (m *Memory) RemoveByChatID(ctx context.Context, chatID int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+
+	for id, q := range m.queries {
+		if q.ChatID == chatID {
+			m.deleteQuery(id)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// RecordFailure records a failed fetch of a query and schedules the next retry
+func (m *Memory) RecordFailure(ctx context.Context, id uint, failure error) *model.Query {
+	return m.update(id, func(q *model.Query) {
+		applyFailure(q, failure, time.Now())
+	})
+}
+
+// RecordSuccess resets the failure history of a query
+func (m *Memory) RecordSuccess(ctx context.Context, id uint) *model.Query {
+	return m.update(id, applySuccess)
+}
+
+// Retry releases a quarantined query of the given chat so it is fetched with the next run
+func (m *Memory) Retry(ctx context.Context, id uint, chatID int64) *model.Query {
+	if q := m.FindQueryByID(ctx, id); q == nil || q.ChatID != chatID {
+		return nil
+	}
+
+	return m.update(id, applyRetry)
+}
+
+// StoreAds creates the snapshots of the ads for the query or updates them if the ads were already seen
+func (m *Memory) StoreAds(ctx context.Context, queryID uint, ads []scraper.Ad) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	// the ads are listed newest first. the oldest is stored first so the ids follow the listing
+	for i := len(ads) - 1; i >= 0; i-- {
+		item := ads[i]
+		ad := model.NewAd(queryID, item, now)
+
+		if i := m.findAd(queryID, item.ID); i >= 0 {
+			m.ads[i].Update(ad)
+			continue
+		}
+
+		ad.ID = m.nextAdID
+		m.nextAdID++
+		m.ads = append(m.ads, ad)
+	}
+
+	return nil
+}
+
+// ListAds returns the snapshots of the ads seen for the query, the newest first
+func (m *Memory) ListAds(ctx context.Context, queryID uint) []model.Ad {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ads := make([]model.Ad, 0, 0)
+
+	for _, ad := range m.ads {
+		if ad.QueryID == queryID {
+			ads = append(ads, ad)
+		}
+	}
+
+	sort.Slice(ads, func(i, j int) bool {
+		if !ads[i].FirstSeenAt.Equal(ads[j].FirstSeenAt) {
+			return ads[i].FirstSeenAt.After(ads[j].FirstSeenAt)
+		}
+
+		return ads[i].ID > ads[j].ID
+	})
+
+	return ads
+}
+
+// IsKnownAd checks if the ad was already seen for the query
+func (m *Memory) IsKnownAd(ctx context.Context, queryID uint, adID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.findAd(queryID, adID) >= 0
+}
+
+// NewAds returns the ads that were not seen for the query yet
+func (m *Memory) NewAds(ctx context.Context, queryID uint, ads []scraper.Ad) []scraper.Ad {
+	newAds := make([]scraper.Ad, 0, 0)
+
+	for _, ad := range ads {
+		if !m.IsKnownAd(ctx, queryID, ad.ID) {
+			newAds = append(newAds, ad)
+		}
+	}
+
+	return newAds
+}
+
+// CountAdsSince counts the ads first seen for the query since the given time
+func (m *Memory) CountAdsSince(ctx context.Context, queryID uint, since time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+
+	for _, ad := range m.ads {
+		if ad.QueryID == queryID && ad.FirstSeenAt.After(since) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// DeleteOlderAds deletes all ads not seen within the retention
+func (m *Memory) DeleteOlderAds(ctx context.Context, retention time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+
+	return m.deleteAds(func(ad model.Ad) bool { return ad.LastSeenAt.Before(cutoff) }), nil
+}
+
+// GetSetting returns the value of the setting. ok is false if the setting was never saved
+func (m *Memory) GetSetting(ctx context.Context, key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.settings[key]
+
+	return value, ok
+}
+
+// SaveSetting creates or updates the setting
+func (m *Memory) SaveSetting(ctx context.Context, key string, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.settings[key] = value
+
+	return nil
+}
+
+// Close does nothing. The data is dropped with the store
+func (m *Memory) Close() {}
+
+func (m *Memory) filterQueries(keep func(q model.Query) bool) []model.Query {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queries := make([]model.Query, 0, 0)
+
+	for _, q := range m.queries {
+		if keep(q) {
+			queries = append(queries, q)
+		}
+	}
+
+	sort.Slice(queries, func(i, j int) bool { return queries[i].ID < queries[j].ID })
+
+	return queries
+}
+
+// update changes the stored query with fn and returns the changed copy
+func (m *Memory) update(id uint, fn func(q *model.Query)) *model.Query {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queries[id]
+
+	if !ok {
+		return nil
+	}
+
+	fn(&q)
+	m.queries[id] = q
+
+	return &q
+}
+
+// findAd returns the index of the ad of the query or -1. The caller holds the lock
+func (m *Memory) findAd(queryID uint, adID string) int {
+	for i, ad := range m.ads {
+		if ad.QueryID == queryID && ad.EbayID == adID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// deleteQuery deletes the query and its ads. The caller holds the lock
+func (m *Memory) deleteQuery(id uint) {
+	delete(m.queries, id)
+	m.deleteAds(func(ad model.Ad) bool { return ad.QueryID == id })
+}
+
+// deleteAds deletes the matching ads and returns their number. The caller holds the lock
+func (m *Memory) deleteAds(match func(ad model.Ad) bool) int64 {
+	kept := make([]model.Ad, 0, len(m.ads))
+
+	for _, ad := range m.ads {
+		if !match(ad) {
+			kept = append(kept, ad)
+		}
+	}
+
+	deleted := int64(len(m.ads) - len(kept))
+	m.ads = kept
+
+	return deleted
+}
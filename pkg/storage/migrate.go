@@ -0,0 +1,372 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+
+// a migration starting with "-- requires: table.column" only runs if the column exists
+var requiresColumn = regexp.MustCompile(`^-- requires: ([a-z0-9_]+)\.([a-z0-9_]+)`)
+
+const createMigrationTable = `CREATE TABLE IF NOT EXISTS "schema_migrations" ("version" integer primary key, "name" varchar(255), "applied_at" datetime)`
+
+// Migration is a change of the database schema. The migrations are applied in the order of their version
+type Migration struct {
+	Version int
+	Name    string
+	sql     string
+	// requires is the table and column the migration needs. Without them it is marked as applied without running
+	requires []string
+}
+
+// MigrationState tells if and when a migration was applied to a database. AppliedAt is nil for pending migrations
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrations returns the migrations built into the binary ordered by their version
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	versions := make(map[int]string)
+
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+
+		if other, ok := versions[version]; ok {
+			return nil, fmt.Errorf("migrations %q and %q have the same version", other, entry.Name())
+		}
+
+		versions[version] = entry.Name()
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+
+		if err != nil {
+			return nil, err
+		}
+
+		m := Migration{Version: version, Name: match[2], sql: string(content)}
+
+		if requires := requiresColumn.FindStringSubmatch(m.sql); requires != nil {
+			m.requires = requires[1:]
+		}
+
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// MigrationStatus opens the sqlite database at the path read only and returns the state of every migration. All
+// migrations are pending for a database that does not exist yet
+func MigrationStatus(dbPath string) ([]MigrationState, error) {
+	migrations, err := Migrations()
+
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time)
+
+	if _, err := os.Stat(dbPath); err == nil {
+		db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer db.Close()
+
+		if applied, err = appliedMigrations(db); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+
+	for _, m := range migrations {
+		state := MigrationState{Migration: m}
+
+		if appliedAt, ok := applied[m.Version]; ok {
+			state.AppliedAt = &appliedAt
+		}
+
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// migrate applies the pending migrations. Every migration runs in its own transaction
+func migrate(db *sql.DB) error {
+	migrations, err := Migrations()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createMigrationTable); err != nil {
+		return fmt.Errorf("could not create migration table: %w", err)
+	}
+
+	applied, err := appliedMigrations(db)
+
+	if err != nil {
+		return err
+	}
+
+	known := make(map[int]bool)
+
+	for _, m := range migrations {
+		known[m.Version] = true
+
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		if m.Version == migrations[0].Version {
+			if err := adoptSchema(db, m); err != nil {
+				return fmt.Errorf("could not adopt existing schema: %w", err)
+			}
+		}
+
+		if err := apply(db, m); err != nil {
+			return fmt.Errorf("could not apply migration %d_%s: %w", m.Version, m.Name, err)
+		}
+
+		log.Info().Int("version", m.Version).Str("name", m.Name).Msg("applied migration")
+	}
+
+	for version := range applied {
+		if !known[version] {
+			log.Warn().Int("version", version).Msg("database has a migration this version does not know. it was created by a newer version")
+		}
+	}
+
+	return nil
+}
+
+func apply(db *sql.DB, m Migration) error {
+	run := true
+
+	if m.requires != nil {
+		columns, err := tableColumns(db, m.requires[0])
+
+		if err != nil {
+			return err
+		}
+
+		run = false
+
+		for _, c := range columns {
+			if c.name == m.requires[1] {
+				run = true
+			}
+		}
+	}
+
+	tx, err := db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	if run {
+		if _, err := tx.Exec(m.sql); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO "schema_migrations" ("version", "name", "applied_at") VALUES (?, ?, ?)`, m.Version, m.Name, time.Now()); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// appliedMigrations returns the versions of the applied migrations with the time they were applied
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	exists := 0
+
+	if err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&exists); err != nil {
+		return nil, err
+	}
+
+	if exists == 0 {
+		return applied, nil
+	}
+
+	rows, err := db.Query(`SELECT "version", "applied_at" FROM "schema_migrations"`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// adoptSchema prepares databases created by gorm's AutoMigrate before the migrations were introduced for the first migration.
+// Their tables may miss columns of later versions of the models. These columns are added so the first migration can be applied
+func adoptSchema(db *sql.DB, m Migration) error {
+	scratch, err := sql.Open("sqlite3", ":memory:")
+
+	if err != nil {
+		return err
+	}
+
+	defer scratch.Close()
+
+	// every connection has its own in-memory database
+	scratch.SetMaxOpenConns(1)
+
+	if _, err := scratch.Exec(m.sql); err != nil {
+		return err
+	}
+
+	tables, err := tableNames(scratch)
+
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		want, err := tableColumns(scratch, table)
+
+		if err != nil {
+			return err
+		}
+
+		have, err := tableColumns(db, table)
+
+		if err != nil {
+			return err
+		}
+
+		if len(have) == 0 {
+			// the table is created by the migration
+			continue
+		}
+
+		existing := make(map[string]bool)
+
+		for _, c := range have {
+			existing[c.name] = true
+		}
+
+		for _, c := range want {
+			if existing[c.name] {
+				continue
+			}
+
+			if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s`, table, c.name, c.kind)); err != nil {
+				return err
+			}
+
+			log.Info().Str("table", table).Str("column", c.name).Msg("added missing column to existing table")
+		}
+	}
+
+	return nil
+}
+
+type column struct {
+	name string
+	kind string
+}
+
+func tableNames(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	names := make([]string, 0, 0)
+
+	for rows.Next() {
+		var name string
+
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// tableColumns returns the columns of the table in their order. A missing table has no columns
+func tableColumns(db *sql.DB, table string) ([]column, error) {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info("%s")`, table))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	columns := make([]column, 0, 0)
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, kind string
+		var defaultValue sql.NullString
+
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, column{name: name, kind: kind})
+	}
+
+	return columns, rows.Err()
+}
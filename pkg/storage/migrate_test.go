@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createFixture creates a sqlite database in a temporary directory from the sql file in testdata and returns its path.
+// An empty fixture creates an empty database
+func createFixture(t *testing.T, fixture string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "alert.db")
+	db, err := sql.Open("sqlite3", path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if fixture == "" {
+		return path
+	}
+
+	script, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(string(script)); err != nil {
+		t.Fatalf("could not load fixture: %v", err)
+	}
+
+	return path
+}
+
+// openFixture opens the database created from the fixture
+func openFixture(t *testing.T, fixture string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", createFixture(t, fixture))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func columnNames(t *testing.T, db *sql.DB, table string) map[string]bool {
+	t.Helper()
+
+	columns, err := tableColumns(db, table)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]bool)
+
+	for _, c := range columns {
+		names[c.name] = true
+	}
+
+	return names
+}
+
+func assertAllApplied(t *testing.T, db *sql.DB) {
+	t.Helper()
+
+	migrations, err := Migrations()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := appliedMigrations(db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != len(migrations) {
+		t.Errorf("%d migrations applied, want %d", len(applied), len(migrations))
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			t.Errorf("migration %d_%s is not applied", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrateAutoMigrateDatabase(t *testing.T) {
+	db := openFixture(t, "automigrate_baseline.sql")
+
+	// the second run has to be a no-op
+	for run := 1; run <= 2; run++ {
+		if err := migrate(db); err != nil {
+			t.Fatalf("run %d: could not migrate: %v", run, err)
+		}
+	}
+
+	assertAllApplied(t, db)
+
+	queries := columnNames(t, db, "queries")
+
+	for _, column := range []string{"source", "exclude_terms", "include_terms", "match_expression", "interval", "failure_count", "quarantined", "failed_previously"} {
+		if !queries[column] {
+			t.Errorf("queries has no column %s", column)
+		}
+	}
+
+	ads := columnNames(t, db, "ads")
+
+	for _, column := range []string{"title", "price_cents", "link", "seller_name", "first_seen_at", "last_seen_at"} {
+		if !ads[column] {
+			t.Errorf("ads has no column %s", column)
+		}
+	}
+
+	if ads["created_at"] {
+		t.Error("ads still has the column created_at")
+	}
+
+	settings := columnNames(t, db, "settings")
+
+	if !settings["key"] || !settings["value"] {
+		t.Error("settings table was not created")
+	}
+
+	var term, cityName string
+	var maxPrice, failureCount int
+
+	err := db.QueryRow(`SELECT "term", "city_name", "max_price", coalesce("failure_count", 0) FROM "queries" WHERE "id" = 1`).Scan(&term, &cityName, &maxPrice, &failureCount)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if term != "fahrrad" || cityName != "Köln" || maxPrice != 200 || failureCount != 0 {
+		t.Errorf("query 1 = %q %q %d %d, want the stored query", term, cityName, maxPrice, failureCount)
+	}
+
+	// the query that failed before starts with one failure
+	if err := db.QueryRow(`SELECT "failure_count" FROM "queries" WHERE "id" = 2`).Scan(&failureCount); err != nil {
+		t.Fatal(err)
+	}
+
+	if failureCount != 1 {
+		t.Errorf("failure count of query 2 = %d, want 1", failureCount)
+	}
+
+	var link string
+
+	if err := db.QueryRow(`SELECT "custom_link" FROM "queries" WHERE "id" = 2`).Scan(&link); err != nil {
+		t.Fatal(err)
+	}
+
+	if link != "https://www.kleinanzeigen.de/s-fahrrad/k0" {
+		t.Errorf("custom link of query 2 = %q", link)
+	}
+
+	rows, err := db.Query(`SELECT "query_id", "ebay_id", "location", "first_seen_at", "last_seen_at" FROM "ads" ORDER BY "query_id", "ebay_id"`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	type ad struct {
+		queryID                       int
+		ebayID, location, first, last string
+	}
+
+	got := make([]ad, 0, 0)
+
+	for rows.Next() {
+		var a ad
+
+		if err := rows.Scan(&a.queryID, &a.ebayID, &a.location, &a.first, &a.last); err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, a)
+	}
+
+	want := []ad{
+		{1, "1001", "Köln", "2024-01-01", "2024-01-03"},
+		{1, "1002", "Bonn", "2024-01-02", "2024-01-02"},
+		{2, "2001", "Berlin", "2024-01-02", "2024-01-02"},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("ads = %+v, want %+v", got, want)
+	}
+
+	for i := range want {
+		g := got[i]
+
+		if g.queryID != want[i].queryID || g.ebayID != want[i].ebayID || g.location != want[i].location ||
+			g.first[:10] != want[i].first || g.last[:10] != want[i].last {
+			t.Errorf("ad %d = %+v, want %+v", i, g, want[i])
+		}
+	}
+
+	if _, err := db.Exec(`INSERT INTO "ads" ("ebay_id", "query_id") VALUES ('1001', 1)`); err == nil {
+		t.Error("duplicate ad was inserted despite the unique index")
+	}
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	db := openFixture(t, "")
+
+	for run := 1; run <= 2; run++ {
+		if err := migrate(db); err != nil {
+			t.Fatalf("run %d: could not migrate: %v", run, err)
+		}
+	}
+
+	assertAllApplied(t, db)
+
+	if columnNames(t, db, "queries")["failed_previously"] {
+		t.Error("new database has the column failed_previously")
+	}
+}
+
+func TestSQLiteOnAutoMigrateDatabase(t *testing.T) {
+	ctx := context.Background()
+
+	path := createFixture(t, "automigrate_baseline.sql")
+
+	s := NewSQLite(path)
+	defer s.Close()
+
+	queries := s.ListForChatID(ctx, 42)
+
+	if len(queries) != 1 || queries[0].Term != "fahrrad" {
+		t.Fatalf("queries of chat 42 = %+v", queries)
+	}
+
+	if !s.IsKnownAd(ctx, 1, "1001") || s.IsKnownAd(ctx, 1, "2001") {
+		t.Error("ads of the old database are not known for their query")
+	}
+
+	states, err := MigrationStatus(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, state := range states {
+		if state.AppliedAt == nil {
+			t.Errorf("migration %d_%s is pending", state.Version, state.Name)
+		}
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	migrations, err := Migrations()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("missing database", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "alert.db")
+		states, err := MigrationStatus(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(states) != len(migrations) {
+			t.Fatalf("%d states, want %d", len(states), len(migrations))
+		}
+
+		for _, s := range states {
+			if s.AppliedAt != nil {
+				t.Errorf("migration %d_%s is applied, want pending", s.Version, s.Name)
+			}
+		}
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("database was created: %v", err)
+		}
+	})
+
+	t.Run("migrated database", func(t *testing.T) {
+		path := createFixture(t, "")
+		db, err := sql.Open("sqlite3", path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer db.Close()
+
+		if err := migrate(db); err != nil {
+			t.Fatal(err)
+		}
+
+		states, err := MigrationStatus(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, s := range states {
+			if s.AppliedAt == nil {
+				t.Errorf("migration %d_%s is pending, want applied", s.Version, s.Name)
+			}
+		}
+	})
+}
@@ -0,0 +1,50 @@
+-- schema of the tables created by gorm's AutoMigrate before the migrations were introduced
+
+CREATE TABLE IF NOT EXISTS "queries" (
+	"id" integer primary key autoincrement,
+	"created_at" datetime,
+	"updated_at" datetime,
+	"deleted_at" datetime,
+	"chat_id" bigint,
+	"source" varchar(50),
+	"term" varchar(100),
+	"radius" integer,
+	"city" integer,
+	"city_name" varchar(100),
+	"max_price" integer,
+	"min_price" integer,
+	"custom_link" varchar(1000),
+	"exclude_terms" varchar(255),
+	"exclude_in_description" bool,
+	"match_expression" varchar(500),
+	"location_filter" varchar(100),
+	"seller_type" varchar(20),
+	"interval" integer,
+	"active_from" integer,
+	"active_to" integer,
+	"failure_count" integer,
+	"last_error" varchar(500),
+	"last_failed_at" datetime,
+	"next_retry_at" datetime,
+	"quarantined" bool
+);
+
+CREATE INDEX IF NOT EXISTS idx_queries_deleted_at ON "queries"(deleted_at);
+CREATE INDEX IF NOT EXISTS chatid ON "queries"(chat_id);
+
+CREATE TABLE IF NOT EXISTS "ads" (
+	"id" integer primary key autoincrement,
+	"ebay_id" varchar(255),
+	"query_id" integer,
+	"location" varchar(510),
+	"created_at" datetime
+);
+
+CREATE INDEX IF NOT EXISTS ad_queryid ON "ads"(query_id);
+
+CREATE TABLE IF NOT EXISTS "settings" (
+	"key" varchar(100),
+	"value" varchar(255),
+	"updated_at" datetime,
+	PRIMARY KEY ("key")
+);
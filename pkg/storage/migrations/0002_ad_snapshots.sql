@@ -0,0 +1,30 @@
+-- ads keep a snapshot of the listing instead of only its id. sqlite can not drop columns, so the table is rebuilt.
+-- duplicates of an ad within a query are merged into one row
+
+CREATE TABLE "ads_snapshots" (
+	"id" integer primary key autoincrement,
+	"ebay_id" varchar(255) NOT NULL,
+	"query_id" integer NOT NULL,
+	"title" varchar(255),
+	"price_raw" varchar(100),
+	"price_cents" bigint,
+	"link" varchar(1000),
+	"location" varchar(510),
+	"seller_name" varchar(255),
+	"seller_type" varchar(20),
+	"first_seen_at" datetime,
+	"last_seen_at" datetime
+);
+
+INSERT INTO "ads_snapshots" ("id", "ebay_id", "query_id", "location", "first_seen_at", "last_seen_at")
+SELECT min("id"), "ebay_id", "query_id", max("location"), min("created_at"), max("created_at")
+FROM "ads"
+WHERE "ebay_id" IS NOT NULL AND "query_id" IS NOT NULL
+GROUP BY "query_id", "ebay_id";
+
+DROP TABLE "ads";
+
+ALTER TABLE "ads_snapshots" RENAME TO "ads";
+
+CREATE UNIQUE INDEX ad_query_ebay_id ON "ads"(query_id, ebay_id);
+CREATE INDEX ad_last_seen_at ON "ads"(last_seen_at);
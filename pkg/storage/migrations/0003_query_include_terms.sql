@@ -0,0 +1,3 @@
+-- words the title of an ad has to contain
+
+ALTER TABLE "queries" ADD COLUMN "include_terms" varchar(255);
@@ -0,0 +1,5 @@
+-- requires: queries.failed_previously
+-- queries that failed before the failure count was introduced start with one failure. only databases created by
+-- older versions have the column failed_previously
+
+UPDATE "queries" SET "failure_count" = 1 WHERE "failed_previously" = 1 AND coalesce("failure_count", 0) = 0;
@@ -0,0 +1,385 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+	"github.com/jinzhu/gorm"
+
+	// import for the database driver
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// SQLite is the Store backed by a sqlite database. gorm does not pass a context to the driver, so the methods built on
+// gorm only check the context before they access the database. The methods of the ads use database/sql with the context
+type SQLite struct {
+	db *gorm.DB
+}
+
+// NewSQLite opens the sqlite database at the path and applies the pending migrations
+func NewSQLite(path string) *SQLite {
+	s := new(SQLite)
+	db, err := gorm.Open("sqlite3", path)
+
+	if err != nil {
+		log.Panic().Err(err).Str("path", path).Msg("sqlite3 database could not be created")
+	}
+
+	log.Info().Str("path", path).Msg("database was created")
+
+	if err := migrate(db.DB()); err != nil {
+		log.Panic().Err(err).Str("path", path).Msg("could not migrate database")
+	}
+
+	s.db = db
+	return s
+}
+
+// GetUniqueChatIDs returns the chats that have queries
+func (s *SQLite) GetUniqueChatIDs(ctx context.Context) []int64 {
+	chatIDs := make([]int64, 0, 0)
+
+	if ctx.Err() != nil {
+		return chatIDs
+	}
+
+	err := s.db.Table("queries").Select("chat_id").Group("chat_id").Pluck("chat_id", &chatIDs).Error
+
+	if err != nil {
+		log.Error().Err(err).Msg("could not get unique chat ids")
+	}
+
+	return chatIDs
+}
+
+// Close closes the created db connection
+func (s *SQLite) Close() {
+	log.Info().Msg("closing database")
+	s.db.Close()
+}
+
+// CreateQuery stores a new query and sets its id
+func (s *SQLite) CreateQuery(ctx context.Context, q *model.Query) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := s.db.Create(q).Error
+
+	if err != nil {
+		log.Error().Err(err).Msg("could not create query")
+		return errors.New("could not create query")
+	}
+
+	return nil
+}
+
+// GetQueries gets all the queries from the db
+func (s *SQLite) GetQueries(ctx context.Context) []model.Query {
+	queries := make([]model.Query, 0, 0)
+
+	if ctx.Err() != nil {
+		return queries
+	}
+
+	err := s.db.Find(&queries).Error
+
+	if err != nil {
+		log.Error().Err(err).Msg("could not get queries")
+		return queries
+	}
+
+	return queries
+}
+
+// ListForChatID gets all the queries for specified chatId
+func (s *SQLite) ListForChatID(ctx context.Context, chatID int64) []model.Query {
+	queries := make([]model.Query, 0, 0)
+
+	if ctx.Err() != nil {
+		return queries
+	}
+
+	err := s.db.Where(&model.Query{ChatID: chatID}).Find(&queries).Error
+
+	if err != nil {
+		log.Error().Err(err).Msg("could not get queries for a specific chat id")
+		return queries
+	}
+
+	return queries
+}
+
+// FindQueryByID find a query by the given id
+func (s *SQLite) FindQueryByID(ctx context.Context, id uint) *model.Query {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	q := model.Query{}
+	err := s.db.Where("id = ?", id).First(&q).Error
+
+	if err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			log.Error().Err(err).Msg("could not get a query by id")
+		}
+
+		return nil
+	}
+
+	return &q
+}
+
+// RemoveByID removes a query by id
+func (s *SQLite) RemoveByID(ctx context.Context, id uint, chatID int64) *model.Query {
+	q := s.FindQueryByID(ctx, id)
+
+	if q == nil || q.ChatID != chatID {
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	s.db.Delete(q)
+
+	return q
+}
+
+// RecordFailure records a failed fetch of a query and schedules the next retry
+func (s *SQLite) RecordFailure(ctx context.Context, id uint, failure error) *model.Query {
+	q := s.FindQueryByID(ctx, id)
+
+	if q == nil {
+		return nil
+	}
+
+	applyFailure(q, failure, time.Now())
+	s.saveFailureState(ctx, q)
+
+	return q
+}
+
+// RecordSuccess resets the failure history of a query
+func (s *SQLite) RecordSuccess(ctx context.Context, id uint) *model.Query {
+	q := s.FindQueryByID(ctx, id)
+
+	if q == nil {
+		return nil
+	}
+
+	applySuccess(q)
+	s.saveFailureState(ctx, q)
+
+	return q
+}
+
+// Retry releases a quarantined query of the given chat so it is fetched with the next run
+func (s *SQLite) Retry(ctx context.Context, id uint, chatID int64) *model.Query {
+	q := s.FindQueryByID(ctx, id)
+
+	if q == nil || q.ChatID != chatID {
+		return nil
+	}
+
+	applyRetry(q)
+	s.saveFailureState(ctx, q)
+
+	return q
+}
+
+// saveFailureState only updates the failure columns so concurrent changes of the query are not overwritten
+func (s *SQLite) saveFailureState(ctx context.Context, q *model.Query) {
+	if err := ctx.Err(); err != nil {
+		log.Error().Err(err).Uint("query_id", q.ID).Msg("could not save failure state of query")
+		return
+	}
+
+	err := s.db.Model(q).UpdateColumns(map[string]interface{}{
+		"failure_count":  q.FailureCount,
+		"last_error":     q.LastError,
+		"last_failed_at": q.LastFailedAt,
+		"next_retry_at":  q.NextRetryAt,
+		"quarantined":    q.Quarantined,
+	}).Error
+
+	if err != nil {
+		log.Error().Err(err).Uint("query_id", q.ID).Msg("could not save failure state of query")
+	}
+}
+
+// SaveQuery saves the changed fields of a query
+func (s *SQLite) SaveQuery(ctx context.Context, q *model.Query) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := s.db.Save(q).Error
+
+	if err != nil {
+		log.Error().Err(err).Uint("query_id", q.ID).Msg("could not save query")
+		return errors.New("could not save query")
+	}
+
+	return nil
+}
+
+// RemoveByChatID removes all queries for a chat id
+func (s *SQLite) RemoveByChatID(ctx context.Context, chatID int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	trx := s.db.Where(&model.Query{ChatID: chatID}).Delete(&model.Query{})
+
+	return int(trx.RowsAffected), trx.Error
+}
+
+// GetSetting returns the value of the setting. ok is false if the setting was never saved
+func (s *SQLite) GetSetting(ctx context.Context, key string) (string, bool) {
+	if ctx.Err() != nil {
+		return "", false
+	}
+
+	var setting model.Setting
+	err := s.db.Where(&model.Setting{Key: key}).First(&setting).Error
+
+	if err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			log.Error().Err(err).Str("key", key).Msg("could not read setting")
+		}
+
+		return "", false
+	}
+
+	return setting.Value, true
+}
+
+// SaveSetting creates or updates the setting
+func (s *SQLite) SaveSetting(ctx context.Context, key string, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := s.db.Save(&model.Setting{Key: key, Value: value}).Error
+
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("could not save setting")
+		return errors.New("could not save setting")
+	}
+
+	return nil
+}
+
+// CountAdsSince counts the ads first seen for the query since the given time
+func (s *SQLite) CountAdsSince(ctx context.Context, queryID uint, since time.Time) int {
+	count := 0
+	err := s.db.DB().QueryRowContext(ctx, `SELECT count(*) FROM "ads" WHERE "query_id" = ? AND "first_seen_at" > ?`, queryID, since).Scan(&count)
+
+	if err != nil {
+		log.Error().Err(err).Uint("query_id", queryID).Msg("could not count ads of query")
+	}
+
+	return count
+}
+
+// DeleteOlderAds deletes all ads not seen within the retention
+func (s *SQLite) DeleteOlderAds(ctx context.Context, retention time.Duration) (int64, error) {
+	res, err := s.db.DB().ExecContext(ctx, `DELETE FROM "ads" WHERE "last_seen_at" < ?`, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// upsertAd inserts the snapshot or updates the snapshot of the same ad of the query. A known seller is not overwritten by an empty one
+const upsertAd = `INSERT INTO "ads" ("ebay_id", "query_id", "title", "price_raw", "price_cents", "link", "location", "seller_name", "seller_type", "first_seen_at", "last_seen_at")
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT ("query_id", "ebay_id") DO UPDATE SET
+	"title" = excluded."title",
+	"price_raw" = excluded."price_raw",
+	"price_cents" = excluded."price_cents",
+	"link" = excluded."link",
+	"location" = excluded."location",
+	"seller_name" = coalesce(nullif(excluded."seller_name", ''), "ads"."seller_name"),
+	"seller_type" = coalesce(nullif(excluded."seller_type", ''), "ads"."seller_type"),
+	"last_seen_at" = excluded."last_seen_at"`
+
+// StoreAds creates the snapshots of the ads for the query or updates them if the ads were already seen.
+// The ads are stored in a single transaction, so either all or none of them are stored
+func (s *SQLite) StoreAds(ctx context.Context, qID uint, ads []scraper.Ad) error {
+	now := time.Now()
+	tx, err := s.db.DB().BeginTx(ctx, nil)
+
+	if err != nil {
+		log.Error().Err(err).Uint("query_id", qID).Msg("could not begin transaction to store ads")
+		return errors.New("could not store ads")
+	}
+
+	// the ads are listed newest first. the oldest is stored first so the ids follow the listing
+	for i := len(ads) - 1; i >= 0; i-- {
+		item := ads[i]
+		ad := model.NewAd(qID, item, now)
+		_, err := tx.ExecContext(ctx, upsertAd, ad.EbayID, ad.QueryID, ad.Title, ad.PriceRaw, ad.PriceCents, ad.Link, ad.Location, ad.SellerName, ad.SellerType, ad.FirstSeenAt, ad.LastSeenAt)
+		if err != nil {
+			tx.Rollback()
+			log.Error().Err(err).Uint("query_id", qID).Str("ad_id", item.ID).Msg("could not store ad")
+			return errors.New("could not store ads")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error().Err(err).Uint("query_id", qID).Msg("could not commit stored ads")
+		return errors.New("could not store ads")
+	}
+
+	return nil
+}
+
+// ListAds returns the snapshots of the ads seen for the query, the newest first
+func (s *SQLite) ListAds(ctx context.Context, qID uint) []model.Ad {
+	ads := make([]model.Ad, 0, 0)
+
+	if ctx.Err() != nil {
+		return ads
+	}
+
+	err := s.db.Where("query_id = ?", qID).Order("first_seen_at desc, id desc").Find(&ads).Error
+
+	if err != nil {
+		log.Error().Err(err).Uint("query_id", qID).Msg("could not get ads of query")
+	}
+
+	return ads
+}
+
+// IsKnownAd checks if the ad was already seen for the query
+func (s *SQLite) IsKnownAd(ctx context.Context, qID uint, adID string) bool {
+	count := 0
+	err := s.db.DB().QueryRowContext(ctx, `SELECT count(*) FROM "ads" WHERE "query_id" = ? AND "ebay_id" = ?`, qID, adID).Scan(&count)
+
+	if err != nil {
+		log.Error().Err(err).Msg("could not check if ad is known")
+		return false
+	}
+
+	return count > 0
+}
+
+// NewAds returns the ads that were not seen for the query yet
+func (s *SQLite) NewAds(ctx context.Context, qID uint, current []scraper.Ad) []scraper.Ad {
+	newAds := make([]scraper.Ad, 0, 0)
+	for _, s1 := range current {
+		if !s.IsKnownAd(ctx, qID, s1.ID) {
+			newAds = append(newAds, s1)
+		}
+	}
+
+	return newAds
+}
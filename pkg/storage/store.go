@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+)
+
+// Store keeps the queries, the ads that were already seen for them and the settings of the bot.
+// Methods returning a query return nil if the query does not exist. The database access is cancelled with the context
+type Store interface {
+	// CreateQuery stores a new query and sets its id
+	CreateQuery(ctx context.Context, q *model.Query) error
+	// SaveQuery saves the changed fields of a query
+	SaveQuery(ctx context.Context, q *model.Query) error
+	FindQueryByID(ctx context.Context, id uint) *model.Query
+	GetQueries(ctx context.Context) []model.Query
+	ListForChatID(ctx context.Context, chatID int64) []model.Query
+	GetUniqueChatIDs(ctx context.Context) []int64
+	// RemoveByID removes the query if it belongs to the chat
+	RemoveByID(ctx context.Context, id uint, chatID int64) *model.Query
+	RemoveByChatID(ctx context.Context, chatID int64) (int, error)
+
+	// RecordFailure records a failed fetch of a query and schedules the next retry. The query is quarantined after model.QuarantineAfter failures in a row
+	RecordFailure(ctx context.Context, id uint, failure error) *model.Query
+	// RecordSuccess resets the failure history of a query
+	RecordSuccess(ctx context.Context, id uint) *model.Query
+	// Retry releases a quarantined query of the given chat so it is fetched with the next run
+	Retry(ctx context.Context, id uint, chatID int64) *model.Query
+
+	// StoreAds creates the snapshots of the ads for the query or updates them if the ads were already seen. The ads are ordered newest first like on the result pages
+	StoreAds(ctx context.Context, queryID uint, ads []scraper.Ad) error
+	// ListAds returns the snapshots of the ads seen for the query, the newest first
+	ListAds(ctx context.Context, queryID uint) []model.Ad
+	IsKnownAd(ctx context.Context, queryID uint, adID string) bool
+	// NewAds returns the ads that were not seen for the query yet
+	NewAds(ctx context.Context, queryID uint, ads []scraper.Ad) []scraper.Ad
+	// CountAdsSince counts the ads first seen for the query since the given time
+	CountAdsSince(ctx context.Context, queryID uint, since time.Time) int
+	// DeleteOlderAds deletes all ads not seen within the retention
+	DeleteOlderAds(ctx context.Context, retention time.Duration) (int64, error)
+
+	// GetSetting returns the value of the setting. ok is false if the setting was never saved
+	GetSetting(ctx context.Context, key string) (string, bool)
+	// SaveSetting creates or updates the setting
+	SaveSetting(ctx context.Context, key string, value string) error
+
+	Close()
+}
+
+// maxErrorLength is the length of the last error kept for a query
+const maxErrorLength = 500
+
+// applyFailure records the failure in the failure fields of the query
+func applyFailure(q *model.Query, failure error, now time.Time) {
+	msg := failure.Error()
+
+	if runes := []rune(msg); len(runes) > maxErrorLength {
+		msg = string(runes[:maxErrorLength])
+	}
+
+	q.FailureCount++
+	q.LastError = &msg
+	q.LastFailedAt = &now
+
+	next := now.Add(q.RetryDelay())
+	q.NextRetryAt = &next
+
+	if q.FailureCount >= model.QuarantineAfter {
+		q.Quarantined = true
+		log.Info().Uint("query_id", q.ID).Int("failure_count", q.FailureCount).Msg("query quarantined")
+	}
+}
+
+// applySuccess resets the failure fields of the query
+func applySuccess(q *model.Query) {
+	q.FailureCount = 0
+	q.NextRetryAt = nil
+	q.Quarantined = false
+}
+
+// applyRetry releases the query from the quarantine. The failure count is reset so the query is not quarantined again
+// with its next failure. The last error is kept for the status
+func applyRetry(q *model.Query) {
+	q.FailureCount = 0
+	q.Quarantined = false
+	q.NextRetryAt = nil
+}
+
+var (
+	_ Store = (*SQLite)(nil)
+	_ Store = (*Memory)(nil)
+)
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+)
+
+// forEachStore runs the test against every implementation of the store
+func forEachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("sqlite", func(t *testing.T) {
+		s := NewSQLite(filepath.Join(t.TempDir(), "alert.db"))
+		defer s.Close()
+
+		test(t, s)
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemory())
+	})
+}
+
+func TestRetryResetsFailures(t *testing.T) {
+	ctx := context.Background()
+
+	forEachStore(t, func(t *testing.T, s Store) {
+		q := model.Query{ChatID: 42, Term: "fahrrad"}
+
+		if err := s.CreateQuery(ctx, &q); err != nil {
+			t.Fatal(err)
+		}
+
+		failure := errors.New("could not get latest ads")
+
+		for i := 0; i < model.QuarantineAfter; i++ {
+			s.RecordFailure(ctx, q.ID, failure)
+		}
+
+		if stored := s.FindQueryByID(ctx, q.ID); !stored.Quarantined || stored.FailureCount != model.QuarantineAfter {
+			t.Fatalf("query after %d failures = %+v, want quarantined", model.QuarantineAfter, stored)
+		}
+
+		if s.Retry(ctx, q.ID, 43) != nil {
+			t.Error("query of another chat was released")
+		}
+
+		retried := s.Retry(ctx, q.ID, 42)
+
+		if retried == nil || retried.Quarantined || retried.FailureCount != 0 || retried.NextRetryAt != nil {
+			t.Fatalf("retried query = %+v, want released without failures", retried)
+		}
+
+		stored := s.FindQueryByID(ctx, q.ID)
+
+		if stored.Quarantined || stored.FailureCount != 0 || stored.LastError == nil {
+			t.Errorf("stored query = %+v, want released with the last error kept", stored)
+		}
+
+		// the next failure starts counting again
+		if failed := s.RecordFailure(ctx, q.ID, failure); failed.Quarantined || failed.FailureCount != 1 {
+			t.Errorf("query after a failure following the retry = %+v, want 1 failure and not quarantined", failed)
+		}
+	})
+}
+
+func TestStoreAds(t *testing.T) {
+	ctx := context.Background()
+
+	forEachStore(t, func(t *testing.T, s Store) {
+		first := []scraper.Ad{
+			{ID: "2", Title: "Fahrrad neu", Price: scraper.ParsePrice("80 €")},
+			{ID: "1", Title: "Fahrrad alt", Price: scraper.ParsePrice("50 €")},
+		}
+
+		if err := s.StoreAds(ctx, 1, first); err != nil {
+			t.Fatal(err)
+		}
+
+		// the seen ad is updated, the new one added
+		second := []scraper.Ad{
+			{ID: "3", Title: "Fahrrad", Price: scraper.ParsePrice("60 €")},
+			{ID: "2", Title: "Fahrrad neu", Price: scraper.ParsePrice("70 €")},
+		}
+
+		if err := s.StoreAds(ctx, 1, second); err != nil {
+			t.Fatal(err)
+		}
+
+		ads := s.ListAds(ctx, 1)
+
+		if len(ads) != 3 || ads[0].EbayID != "3" || ads[1].EbayID != "2" || ads[2].EbayID != "1" {
+			t.Fatalf("ads = %+v, want 3, 2 and 1", ads)
+		}
+
+		if ads[1].PriceRaw != "70 €" {
+			t.Errorf("price of the updated ad = %q, want 70 €", ads[1].PriceRaw)
+		}
+
+		if !s.IsKnownAd(ctx, 1, "1") || s.IsKnownAd(ctx, 2, "1") {
+			t.Error("ads are not stored per query")
+		}
+	})
+}
+
+func TestSQLiteStoreAdsRollsBack(t *testing.T) {
+	ctx := context.Background()
+
+	s := NewSQLite(filepath.Join(t.TempDir(), "alert.db"))
+	defer s.Close()
+
+	err := s.db.Exec(`CREATE TRIGGER "reject_ad" BEFORE INSERT ON "ads" WHEN NEW."ebay_id" = 'broken'
+		BEGIN SELECT RAISE(ABORT, 'rejected'); END`).Error
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ads := []scraper.Ad{{ID: "3", Title: "Fahrrad"}, {ID: "broken", Title: "Fahrrad"}, {ID: "1", Title: "Fahrrad"}}
+
+	if err := s.StoreAds(ctx, 1, ads); err == nil {
+		t.Fatal("storing a rejected ad did not fail")
+	}
+
+	if stored := s.ListAds(ctx, 1); len(stored) != 0 {
+		t.Errorf("ads stored before the failure were not rolled back: %+v", stored)
+	}
+
+	// the store is still usable after the rollback
+	if err := s.StoreAds(ctx, 1, ads[:1]); err != nil || !s.IsKnownAd(ctx, 1, "3") {
+		t.Errorf("could not store ads after the rollback: %v", err)
+	}
+}
+
+func TestStoreAdsCancelled(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := s.StoreAds(ctx, 1, []scraper.Ad{{ID: "1", Title: "Fahrrad"}}); err == nil {
+			t.Error("ads were stored with a cancelled context")
+		}
+
+		if s.IsKnownAd(context.Background(), 1, "1") {
+			t.Error("ad is known after storing it with a cancelled context")
+		}
+	})
+}
@@ -0,0 +1,20 @@
+-- database created by gorm's AutoMigrate of the first release, before the migrations were introduced
+
+CREATE TABLE "queries" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"chat_id" bigint,"term" varchar(100),"radius" integer,"city" integer,"city_name" varchar(100),"max_price" integer,"min_price" integer,"custom_link" varchar(1000),"failed_previously" bool );
+CREATE INDEX idx_queries_deleted_at ON "queries"(deleted_at) ;
+CREATE INDEX chatid ON "queries"(chat_id) ;
+CREATE TABLE "ads" ("id" integer primary key autoincrement,"ebay_id" varchar(255),"query_id" integer,"location" varchar(510),"created_at" datetime );
+CREATE INDEX ad_queryid ON "ads"(query_id) ;
+
+INSERT INTO "queries" ("created_at", "updated_at", "chat_id", "term", "radius", "city", "city_name", "max_price", "failed_previously")
+VALUES ('2024-01-01 10:00:00+00:00', '2024-01-01 10:00:00+00:00', 42, 'fahrrad', 10, 945, 'Köln', 200, 0);
+
+INSERT INTO "queries" ("created_at", "updated_at", "chat_id", "custom_link", "failed_previously")
+VALUES ('2024-01-02 10:00:00+00:00', '2024-01-02 10:00:00+00:00', 43, 'https://www.kleinanzeigen.de/s-fahrrad/k0', 1);
+
+-- ad 1 was stored twice for the first query
+INSERT INTO "ads" ("ebay_id", "query_id", "location", "created_at") VALUES
+	('1001', 1, 'Köln', '2024-01-01 10:00:00+00:00'),
+	('1002', 1, 'Bonn', '2024-01-02 10:00:00+00:00'),
+	('1001', 1, 'Köln', '2024-01-03 10:00:00+00:00'),
+	('2001', 2, 'Berlin', '2024-01-02 10:00:00+00:00');
@@ -0,0 +1,333 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/alert"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/fakesite"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/storage"
+)
+
+const testChatID = 42
+
+const testAdminChatID = 7
+
+type sentMessage struct {
+	ChatID int64
+	Text   string
+}
+
+// fakeTelegram answers the requests of the bot api in place of telegram. Updates are handed out by getUpdates and the
+// sent messages are recorded
+type fakeTelegram struct {
+	mu      sync.Mutex
+	updates []tgbotapi.Update
+	sent    []sentMessage
+	nextID  int
+}
+
+func (f *fakeTelegram) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	var result interface{} = true
+
+	switch path.Base(req.URL.Path) {
+	case "getMe":
+		result = tgbotapi.User{ID: 1, UserName: "alert_test_bot", IsBot: true}
+	case "getUpdates":
+		result = f.pendingUpdates(req.Form.Get("offset"))
+	case "sendMessage":
+		chatID, _ := strconv.ParseInt(req.Form.Get("chat_id"), 10, 64)
+
+		f.mu.Lock()
+		f.sent = append(f.sent, sentMessage{ChatID: chatID, Text: req.Form.Get("text")})
+		f.mu.Unlock()
+
+		result = tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: chatID}}
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"ok": true, "result": result})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(data)),
+		Request:    req,
+	}, nil
+}
+
+// pendingUpdates returns the updates starting with the offset. Without updates it waits a bit like a long poll
+func (f *fakeTelegram) pendingUpdates(offset string) []tgbotapi.Update {
+	from, _ := strconv.Atoi(offset)
+
+	f.mu.Lock()
+	pending := make([]tgbotapi.Update, 0, len(f.updates))
+
+	for _, u := range f.updates {
+		if u.UpdateID >= from {
+			pending = append(pending, u)
+		}
+	}
+	f.mu.Unlock()
+
+	if len(pending) == 0 {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	return pending
+}
+
+// send queues a message of the user as update
+func (f *fakeTelegram) send(chatID int64, text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	message := &tgbotapi.Message{MessageID: f.nextID, Chat: &tgbotapi.Chat{ID: chatID}, Text: text}
+
+	if strings.HasPrefix(text, "/") {
+		length := strings.IndexByte(text+" ", ' ')
+		message.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}}
+	}
+
+	f.updates = append(f.updates, tgbotapi.Update{UpdateID: f.nextID, Message: message})
+}
+
+func (f *fakeTelegram) messages() []sentMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]sentMessage(nil), f.sent...)
+}
+
+// command sends the text to the bot in the test chat and returns the answer
+func (f *fakeTelegram) command(t *testing.T, text string) string {
+	t.Helper()
+
+	return f.commandIn(t, testChatID, text)
+}
+
+// commandIn sends the text to the bot in the chat and returns the answer
+func (f *fakeTelegram) commandIn(t *testing.T, chatID int64, text string) string {
+	t.Helper()
+
+	before := len(f.messages())
+	f.send(chatID, text)
+
+	deadline := time.Now().Add(time.Second * 5)
+
+	for time.Now().Before(deadline) {
+		if sent := f.messages(); len(sent) > before {
+			if sent[before].ChatID != chatID {
+				t.Errorf("answer of %q was sent to chat %d", text, sent[before].ChatID)
+			}
+
+			return sent[before].Text
+		}
+
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	t.Fatalf("no answer to %q", text)
+	return ""
+}
+
+// startBot runs a bot on top of the store against the fake telegram api until stop is called or the test ends
+func startBot(t *testing.T, store storage.Store) (bot *Bot, telegram *fakeTelegram, stop func()) {
+	ctx := context.Background()
+
+	t.Helper()
+
+	telegram = &fakeTelegram{}
+	bot = CreateBot("test-token", store, alert.NewService(store))
+	bot.SetAdminChatID(testAdminChatID)
+
+	api, err := tgbotapi.NewBotAPIWithClient("test-token", &http.Client{Transport: telegram})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot.internalBot = api
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		bot.Start(ctx)
+	}()
+
+	var once sync.Once
+
+	stop = func() {
+		once.Do(func() {
+			cancel()
+			<-stopped
+
+			if !bot.Wait(time.Second * 5) {
+				t.Error("commands did not finish")
+			}
+		})
+	}
+
+	t.Cleanup(stop)
+
+	return bot, telegram, stop
+}
+
+func TestBotCommands(t *testing.T) {
+	ctx := context.Background()
+
+	site := fakesite.Start(t)
+	site.AddAd(fakesite.Ad{ID: "100", Title: "Fahrrad alt", Price: "50 €", Location: "50667 Köln"})
+
+	store := storage.NewMemory()
+	_, telegram, _ := startBot(t, store)
+
+	answer := telegram.command(t, "/add fahrrad, Köln, 10, 200")
+
+	if answer != "Suche für <b>fahrrad</b> in <b>Köln</b> hinzugefügt. ID: <b>1</b>" {
+		t.Fatalf("/add answered %q", answer)
+	}
+
+	queries := store.ListForChatID(ctx, testChatID)
+
+	if len(queries) != 1 || queries[0].Term != "fahrrad" || queries[0].MaxPrice == nil || *queries[0].MaxPrice != 200 {
+		t.Fatalf("stored queries = %+v", queries)
+	}
+
+	if !store.IsKnownAd(ctx, queries[0].ID, "100") {
+		t.Error("the current ads were not stored with the new query")
+	}
+
+	if answer := telegram.command(t, "/list"); !strings.Contains(answer, "Suchbegriff: <b>fahrrad</b>") || !strings.Contains(answer, "Max Preis: <b>200 €</b>") {
+		t.Errorf("/list answered %q", answer)
+	}
+
+	telegram.command(t, "/filter 1, ohne, defekt hülle")
+
+	if q := store.FindQueryByID(ctx, 1); q == nil || strings.Join(q.Excludes(), " ") != "defekt hülle" {
+		t.Errorf("exclude terms were not stored: %+v", q)
+	}
+
+	telegram.command(t, "/interval 1, 30, 07:00-23:00")
+
+	if q := store.FindQueryByID(ctx, 1); q == nil || q.Interval != 30 || q.ActiveFrom == nil || *q.ActiveFrom != 7*60 {
+		t.Errorf("interval was not stored: %+v", q)
+	}
+
+	if answer := telegram.command(t, "/link https://example.com/s-fahrrad/k0"); !strings.Contains(answer, "/link {link}") {
+		t.Errorf("/link with a foreign link answered %q", answer)
+	}
+
+	if answer := telegram.command(t, "/remove 2"); answer != "Suche nicht gefunden." {
+		t.Errorf("/remove of an unknown query answered %q", answer)
+	}
+
+	if answer := telegram.command(t, "/remove 1"); answer != "Suche für fahrrad entfernt" {
+		t.Errorf("/remove answered %q", answer)
+	}
+
+	if queries := store.ListForChatID(ctx, testChatID); len(queries) != 0 {
+		t.Errorf("queries left after /remove: %+v", queries)
+	}
+
+	if answer := telegram.command(t, "/unbekannt"); answer != "Das Kommando kenne ich nicht." {
+		t.Errorf("unknown command answered %q", answer)
+	}
+}
+
+func TestBotProxiesCommand(t *testing.T) {
+	_, telegram, _ := startBot(t, storage.NewMemory())
+
+	if answer := telegram.command(t, "/proxies"); answer != "Das Kommando kenne ich nicht." {
+		t.Errorf("/proxies outside the admin chat answered %q", answer)
+	}
+
+	if answer := telegram.commandIn(t, testAdminChatID, "/proxies"); answer != "Es werden keine Proxies genutzt." {
+		t.Errorf("/proxies in the admin chat answered %q", answer)
+	}
+}
+
+func TestBotSendsNewAds(t *testing.T) {
+	site := fakesite.Start(t)
+	site.AddAd(fakesite.Ad{ID: "100", Title: "Fahrrad alt", Price: "50 €", Location: "50667 Köln"})
+
+	store := storage.NewMemory()
+	bot, telegram, _ := startBot(t, store)
+	service := alert.NewService(store)
+	ctx := context.Background()
+
+	q, err := service.AddQuery(ctx, scraper.DefaultSource, "fahrrad", nil, "Köln", 10, nil, nil, testChatID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	site.AddAd(fakesite.Ad{ID: "200", Title: "Fahrrad neu", Price: "80 €", Location: "50667 Köln"})
+
+	ads, err := service.GetLatest(ctx, q.ID, 5)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bot.SendAds(testChatID, ads, *q); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := telegram.messages()
+
+	if len(sent) != 1 || sent[0].ChatID != testChatID {
+		t.Fatalf("sent messages = %+v, want the new ad", sent)
+	}
+
+	if !strings.Contains(sent[0].Text, "Fahrrad neu") || !strings.Contains(sent[0].Text, "/s-anzeige/") {
+		t.Errorf("message of the new ad = %q", sent[0].Text)
+	}
+}
+
+func TestBotSavesOffset(t *testing.T) {
+	ctx := context.Background()
+
+	store := storage.NewMemory()
+	_, telegram, stop := startBot(t, store)
+
+	telegram.command(t, "/help")
+	telegram.command(t, "/help")
+	stop()
+
+	if offset, ok := store.GetSetting(ctx, model.SettingTelegramOffset); !ok || offset != "3" {
+		t.Errorf("saved offset = %q %v, want 3", offset, ok)
+	}
+
+	// a restarted bot continues after the handled updates
+	_, telegram, _ = startBot(t, store)
+	telegram.nextID = 2
+	telegram.command(t, "/help")
+
+	if sent := telegram.messages(); len(sent) != 1 {
+		t.Errorf("restarted bot sent %d messages, want 1", len(sent))
+	}
+}
@@ -1,14 +1,20 @@
 package telegram
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"html"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/alert"
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/match"
 	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
 	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
 
@@ -17,21 +23,50 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
+const retryCallbackPrefix = "retry:"
+
+const timeLayout = "02.01.2006 15:04"
+
+// maxInterval is the longest polling interval in minutes
+const maxInterval = 24 * 60
+
 // Bot will store the token the internal telegram bto and the storage
 type Bot struct {
 	token       string
 	internalBot *tgbotapi.BotAPI
-	storage     *storage.Storage
+	storage     storage.Store
+	alerts      *alert.Service
+	adminChatID int64
+	// commandTimeout is the deadline of commands that fetch from the source
+	commandTimeout time.Duration
+
+	// ctx is the context of the running commands. It is cancelled if they do not finish in time on shutdown
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pending sync.WaitGroup
 }
 
-// CreateBot will create a new bot with the given token and storage
-func CreateBot(token string, storage *storage.Storage) *Bot {
+// CreateBot will create a new bot with the given token and storage. New queries are added with the service
+func CreateBot(token string, storage storage.Store, alerts *alert.Service) *Bot {
 	bot := new(Bot)
 	bot.token = token
 	bot.storage = storage
+	bot.alerts = alerts
+	bot.commandTimeout = time.Minute
+	bot.ctx, bot.cancel = context.WithCancel(context.Background())
 	return bot
 }
 
+// SetCommandTimeout sets the deadline of commands that fetch from the source
+func (b *Bot) SetCommandTimeout(timeout time.Duration) {
+	b.commandTimeout = timeout
+}
+
+// SetAdminChatID sets the chat that may use the admin commands
+func (b *Bot) SetAdminChatID(chatID int64) {
+	b.adminChatID = chatID
+}
+
 // Init will create the internal bot
 func (b *Bot) Init() {
 	bot, err := tgbotapi.NewBotAPI(b.token)
@@ -44,11 +79,12 @@ func (b *Bot) Init() {
 	}
 }
 
-// Start starts the bot and listens for commands this will not return
-func (b *Bot) Start() {
-	lastUpdateID := -1
+// Start starts the bot and listens for commands until the context is done. The offset of the handled updates is saved
+// on return so no command is handled twice after a restart. Use Wait to wait for the commands that are still running
+func (b *Bot) Start(ctx context.Context) {
+	lastUpdateID := b.loadOffset() - 1
 
-	for {
+	for ctx.Err() == nil {
 		u := tgbotapi.NewUpdate(lastUpdateID + 1)
 		u.Timeout = 60
 
@@ -59,7 +95,29 @@ func (b *Bot) Start() {
 			continue
 		}
 
-		for update := range updates {
+		for {
+			var update tgbotapi.Update
+			var ok bool
+
+			select {
+			case <-ctx.Done():
+				b.internalBot.StopReceivingUpdates()
+				b.saveOffset(lastUpdateID + 1)
+				log.Info().Msg("telegram bot stopped")
+				return
+			case update, ok = <-updates:
+			}
+
+			if !ok {
+				break
+			}
+
+			if update.CallbackQuery != nil {
+				callback := update.CallbackQuery
+				b.handle(func() { b.handleCallback(callback) })
+				lastUpdateID = update.UpdateID
+				continue
+			}
 
 			if update.Message == nil { // ignore any non-Message updates
 				continue
@@ -73,23 +131,32 @@ func (b *Bot) Start() {
 			switch update.Message.Command() {
 			case "start":
 				log.Debug().Str("telegram_username", update.Message.Chat.UserName).Msg("Starting bot.")
-				b.sendMsgRaw(generateHelpText(), update.Message.Chat.ID)
+				b.handle(func() {
+					b.sendMsgRaw(generateHelpText(), update.Message.Chat.ID)
+				})
 			case "help":
-				b.sendMsgRaw(generateHelpText(), update.Message.Chat.ID)
+				b.handle(func() {
+					b.sendMsgRaw(generateHelpText(), update.Message.Chat.ID)
+				})
 			case "list":
-				go func() {
-					queries := b.storage.ListForChatID(update.Message.Chat.ID)
+				b.handle(func() {
+					queries := b.storage.ListForChatID(b.ctx, update.Message.Chat.ID)
 					b.sendQueries(update.Message.Chat.ID, queries)
-				}()
+				})
 			case "add":
-				go func() {
+				b.handle(func() {
+					cmdCtx, cancel := context.WithTimeout(b.ctx, b.commandTimeout)
+					defer cancel()
+
 					msg := "success"
-					q, success := getQueryFromArgs(update.Message.CommandArguments(), update.Message.Chat.ID, b.storage)
+					q, err := getQueryFromArgs(cmdCtx, update.Message.CommandArguments(), update.Message.Chat.ID, b.alerts)
 
-					if !success {
+					if errors.Is(err, errUsage) {
 						msg = "Um eine Suche hinzuzufügen schreibe <code>/add {Suchbegriff}, {Stadt/PLZ}, {Radius}, {Max Preis ohne \"€\", \",\",\".\"}, {Min Preis ohne \"€\", \",\",\".\"}?</code>"
+					} else if err != nil {
+						msg = ErrorText(err)
 					} else {
-						msg = fmt.Sprintf("Suche für <b>%s</b> in <b>%s</b> hinzugefügt. ID: <b>%d</b>", q.Term, q.CityName, q.ID)
+						msg = fmt.Sprintf("Suche für <b>%s</b> in <b>%s</b> hinzugefügt. ID: <b>%d</b>", html.EscapeString(q.Term), html.EscapeString(q.CityName), q.ID)
 						log.Info().
 							Str("telegram_username", update.Message.Chat.UserName).
 							Str("term", q.Term).
@@ -99,16 +166,21 @@ func (b *Bot) Start() {
 					}
 
 					b.sendMsgRaw(msg, update.Message.Chat.ID)
-				}()
+				})
 			case "link":
-				go func() {
+				b.handle(func() {
+					cmdCtx, cancel := context.WithTimeout(b.ctx, b.commandTimeout)
+					defer cancel()
+
 					msg := "success"
-					q, err := b.storage.AddNewQueryViaLink(update.Message.CommandArguments(), update.Message.Chat.ID)
+					q, err := getLinkQueryFromArgs(cmdCtx, update.Message.CommandArguments(), update.Message.Chat.ID, b.alerts)
 
-					if err != nil {
-						msg = "Um eine Suche via Link hinzuzufügen nutze <code>/add {link}</code> mit einem validen Link"
+					if errors.Is(err, errUsage) || errors.Is(err, scraper.ErrInvalidLink) {
+						msg = "Um eine Suche via Link hinzuzufügen nutze <code>/link {link}, {Max Preis ohne \"€\", \",\",\".\"}?, {Min Preis ohne \"€\", \",\",\".\"}?</code> mit einem validen Link"
+					} else if err != nil {
+						msg = ErrorText(err)
 					} else {
-						msg = fmt.Sprintf("Linksuche für <b>%s</b> hinzugefügt. ID: <b>%d</b>", *q.CustomLink, q.ID)
+						msg = fmt.Sprintf("Linksuche für <b>%s</b> hinzugefügt. ID: <b>%d</b>", html.EscapeString(*q.CustomLink), q.ID)
 						log.Info().
 							Str("telegram_username", update.Message.Chat.UserName).
 							Str("link", *q.CustomLink).
@@ -116,9 +188,9 @@ func (b *Bot) Start() {
 					}
 
 					b.sendMsgRaw(msg, update.Message.Chat.ID)
-				}()
+				})
 			case "remove":
-				go func() {
+				b.handle(func() {
 					msg := "success"
 					args := update.Message.CommandArguments()
 
@@ -130,7 +202,7 @@ func (b *Bot) Start() {
 						if err != nil {
 							msg = "Konnte ID nicht lesen. Diese sollte eine ganze positive Zahl sein."
 						} else {
-							removedQ := b.storage.RemoveByID(uint(id), update.Message.Chat.ID)
+							removedQ := b.storage.RemoveByID(b.ctx, uint(id), update.Message.Chat.ID)
 							if removedQ == nil {
 								msg = "Suche nicht gefunden."
 							} else {
@@ -153,18 +225,120 @@ func (b *Bot) Start() {
 					}
 
 					b.sendMsgRaw(msg, update.Message.Chat.ID)
-				}()
+				})
+			case "filter":
+				b.handle(func() {
+					msg := setFilterFromArgs(b.ctx, update.Message.CommandArguments(), update.Message.Chat.ID, b.storage)
+					b.sendMsgRaw(msg, update.Message.Chat.ID)
+				})
+			case "match":
+				b.handle(func() {
+					msg := setMatchFromArgs(b.ctx, update.Message.CommandArguments(), update.Message.Chat.ID, b.storage)
+					b.sendMsgRaw(msg, update.Message.Chat.ID)
+				})
+			case "interval":
+				b.handle(func() {
+					msg := setIntervalFromArgs(b.ctx, update.Message.CommandArguments(), update.Message.Chat.ID, b.storage)
+					b.sendMsgRaw(msg, update.Message.Chat.ID)
+				})
+			case "proxies":
+				b.handle(func() {
+					if b.adminChatID == 0 || update.Message.Chat.ID != b.adminChatID {
+						b.sendMsgRaw("Das Kommando kenne ich nicht.", update.Message.Chat.ID)
+						return
+					}
+
+					b.sendProxyStats(update.Message.Chat.ID)
+				})
+			case "status":
+				b.handle(func() {
+					queries := b.storage.ListForChatID(b.ctx, update.Message.Chat.ID)
+					b.sendStatus(update.Message.Chat.ID, queries)
+				})
+			case "retry":
+				b.handle(func() {
+					id, err := strconv.ParseUint(strings.Trim(update.Message.CommandArguments(), " "), 10, 0)
+
+					if err != nil {
+						b.sendMsgRaw("Um eine pausierte Suche erneut zu versuchen schreibe <code>/retry {ID}</code>.", update.Message.Chat.ID)
+						return
+					}
+
+					b.sendMsgRaw(b.retry(uint(id), update.Message.Chat.ID), update.Message.Chat.ID)
+				})
 			case "clear":
-				go func() {
+				b.handle(func() {
 					b.sendMsgRaw("kommt bald.", update.Message.Chat.ID)
-				}()
+				})
 			default:
-				b.sendMsgRaw("Das Kommando kenne ich nicht.", update.Message.Chat.ID)
+				b.handle(func() {
+					b.sendMsgRaw("Das Kommando kenne ich nicht.", update.Message.Chat.ID)
+				})
 			}
 
 			lastUpdateID = update.UpdateID
 		}
 	}
+
+	b.saveOffset(lastUpdateID + 1)
+}
+
+// Wait waits until the running commands finished. After the timeout the commands are cancelled and false is returned
+func (b *Bot) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+
+	go func() {
+		b.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		b.cancel()
+		return false
+	}
+}
+
+// handle runs a command in the background and tracks it for Wait
+func (b *Bot) handle(command func()) {
+	b.pending.Add(1)
+
+	go func() {
+		defer b.pending.Done()
+		command()
+	}()
+}
+
+// loadOffset returns the id of the next update to handle. 0 if no offset was saved
+func (b *Bot) loadOffset() int {
+	value, ok := b.storage.GetSetting(b.ctx, model.SettingTelegramOffset)
+
+	if !ok {
+		return 0
+	}
+
+	offset, err := strconv.Atoi(value)
+
+	if err != nil {
+		log.Warn().Err(err).Str("offset", value).Msg("could not read saved telegram update offset")
+		return 0
+	}
+
+	log.Info().Int("offset", offset).Msg("continuing with saved telegram update offset")
+
+	return offset
+}
+
+func (b *Bot) saveOffset(offset int) {
+	if offset <= 0 {
+		return
+	}
+
+	if err := b.storage.SaveSetting(b.ctx, model.SettingTelegramOffset, strconv.Itoa(offset)); err == nil {
+		log.Info().Int("offset", offset).Msg("saved telegram update offset")
+	}
 }
 
 // SendAds send the given ad the the given chatId
@@ -184,6 +358,147 @@ func (b *Bot) SendAds(chatID int64, ads []scraper.Ad, q model.Query) error {
 	return nil
 }
 
+// SendQuarantined tells the user that the query was paused and offers to retry it
+func (b *Bot) SendQuarantined(chatID int64, q model.Query) error {
+	term := q.Term
+
+	if q.CustomLink != nil {
+		term = "Link"
+	}
+
+	lastError := ""
+	if q.LastError != nil {
+		lastError = *q.LastError
+	}
+
+	msg := fmt.Sprintf("Anzeigen für <b>%s</b> (ID: %d) konnten %d mal in Folge nicht geladen werden. Die Suche wurde pausiert.\nLetzter Fehler: %s",
+		html.EscapeString(term), q.ID, q.FailureCount, html.EscapeString(lastError))
+
+	telegramMessage := tgbotapi.NewMessage(chatID, msg)
+	telegramMessage.ParseMode = tgbotapi.ModeHTML
+	telegramMessage.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Erneut versuchen", fmt.Sprintf("%s%d", retryCallbackPrefix, q.ID))),
+	)
+
+	_, err := b.internalBot.Send(telegramMessage)
+
+	if err != nil {
+		log.Warn().Err(err).Uint("query_id", q.ID).Msg("could not send quarantine message")
+	}
+
+	return err
+}
+
+func (b *Bot) handleCallback(callback *tgbotapi.CallbackQuery) {
+	if callback.Message == nil || !strings.HasPrefix(callback.Data, retryCallbackPrefix) {
+		b.internalBot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(callback.Data, retryCallbackPrefix), 10, 0)
+
+	if err != nil {
+		log.Warn().Err(err).Str("callback_data", callback.Data).Msg("could not parse callback data")
+		b.internalBot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	msg := b.retry(uint(id), callback.Message.Chat.ID)
+	b.internalBot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	b.sendMsgRaw(msg, callback.Message.Chat.ID)
+}
+
+func (b *Bot) retry(id uint, chatID int64) string {
+	q := b.storage.Retry(b.ctx, id, chatID)
+
+	if q == nil {
+		return "Suche nicht gefunden."
+	}
+
+	log.Info().Uint("query_id", q.ID).Msg("quarantined query released by user")
+
+	return fmt.Sprintf("Die Suche mit der ID <b>%d</b> wird beim nächsten Durchlauf erneut versucht.", q.ID)
+}
+
+func (b *Bot) sendStatus(chatID int64, queries []model.Query) {
+	if len(queries) == 0 {
+		b.sendMsgRaw("Keine Suchen gefunden. Füge ein mit <code>/add</code> hinzu.", chatID)
+		return
+	}
+
+	var sb strings.Builder
+	f := fmt.Sprintf
+
+	for i, q := range queries {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+
+		term := q.Term
+		if q.CustomLink != nil {
+			term = "Link"
+		}
+
+		sb.WriteString(f("<b>%s</b> (ID: %d): ", html.EscapeString(term), q.ID))
+
+		switch {
+		case q.Quarantined:
+			sb.WriteString(f("pausiert. <code>/retry %d</code> zum erneuten Versuchen", q.ID))
+		case q.FailureCount > 0:
+			sb.WriteString("fehlerhaft")
+		default:
+			sb.WriteString("ok")
+		}
+
+		if q.FailureCount > 0 {
+			sb.WriteString(f("\nFehler in Folge: %d", q.FailureCount))
+
+			if q.LastError != nil && q.LastFailedAt != nil {
+				sb.WriteString(f("\nLetzter Fehler am %s: %s", q.LastFailedAt.Format(timeLayout), html.EscapeString(*q.LastError)))
+			}
+
+			if !q.Quarantined && q.NextRetryAt != nil {
+				sb.WriteString(f("\nNächster Versuch: %s", q.NextRetryAt.Format(timeLayout)))
+			}
+		}
+	}
+
+	b.sendMsgRaw(sb.String(), chatID)
+}
+
+func (b *Bot) sendProxyStats(chatID int64) {
+	stats := scraper.HTTPClient().ProxyStats()
+
+	if len(stats) == 0 {
+		b.sendMsgRaw("Es werden keine Proxies genutzt.", chatID)
+		return
+	}
+
+	var sb strings.Builder
+	f := fmt.Sprintf
+
+	for i, stat := range stats {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+
+		sb.WriteString(f("<code>%s</code>\n", html.EscapeString(stat.URL)))
+		sb.WriteString(f("Erfolgreich: %d, Fehlgeschlagen: %d\n", stat.Successes, stat.Failures))
+
+		if stat.Healthy {
+			sb.WriteString("Status: ok")
+		} else {
+			sb.WriteString(f("Status: gesperrt bis %s", stat.UnhealthyUntil.Format(timeLayout)))
+		}
+
+		if stat.LastError != "" {
+			sb.WriteString(f("\nLetzter Fehler: %s", html.EscapeString(stat.LastError)))
+		}
+	}
+
+	b.sendMsgRaw(sb.String(), chatID)
+}
+
 func (b *Bot) SendMsg(chatID int64, msg string) error {
 	return b.sendMsg(msg, msg, chatID)
 }
@@ -240,13 +555,17 @@ func (b *Bot) sendMsg(msg string, raw string, chatID int64) error {
 func formatQuery(q model.Query) string {
 	var b strings.Builder
 	f := fmt.Sprintf
+	if q.Source != "" && q.Source != scraper.DefaultSource {
+		b.WriteString(f("Quelle: <b>%s</b>\n", html.EscapeString(q.Source)))
+	}
+
 	if q.CustomLink != nil {
-		b.WriteString(f("Link: %s\n", *q.CustomLink))
+		b.WriteString(f("Link: %s\n", html.EscapeString(*q.CustomLink)))
 		b.WriteString(f("ID: <b>%v</b>", q.ID))
 	} else {
-		b.WriteString(f("Suchbegriff: <b>%s</b>\n", q.Term))
+		b.WriteString(f("Suchbegriff: <b>%s</b>\n", html.EscapeString(q.Term)))
 		b.WriteString(f("Radius: <b>%v km</b>\n", q.Radius))
-		b.WriteString(f("Stadt: <b>%s</b>\n", q.CityName))
+		b.WriteString(f("Stadt: <b>%s</b>\n", html.EscapeString(q.CityName)))
 		b.WriteString(f("ID: <b>%v</b>", q.ID))
 
 		if q.MaxPrice != nil {
@@ -258,6 +577,46 @@ func formatQuery(q model.Query) string {
 		}
 	}
 
+	if q.CustomLink != nil && q.MaxPrice != nil {
+		b.WriteString(f("\nMax Preis: <b>%v €</b>", *q.MaxPrice))
+	}
+
+	if q.CustomLink != nil && q.MinPrice != nil {
+		b.WriteString(f("\nMin Preis: <b>%v €</b>", *q.MinPrice))
+	}
+
+	if includes := q.Includes(); len(includes) > 0 {
+		b.WriteString(f("\nMit: <b>%s</b>", html.EscapeString(strings.Join(includes, ", "))))
+	}
+
+	if excludes := q.Excludes(); len(excludes) > 0 {
+		b.WriteString(f("\nOhne: <b>%s</b>", html.EscapeString(strings.Join(excludes, ", "))))
+
+		if q.ExcludeInDescription {
+			b.WriteString(" (auch in der Beschreibung)")
+		}
+	}
+
+	if q.MatchExpression != nil {
+		b.WriteString(f("\nAusdruck: <code>%s</code>", html.EscapeString(*q.MatchExpression)))
+	}
+
+	if q.Interval > 0 {
+		b.WriteString(f("\nIntervall: <b>%d min</b>", q.Interval))
+	}
+
+	if q.ActiveFrom != nil && q.ActiveTo != nil {
+		b.WriteString(f("\nAktiv: <b>%s-%s</b>", formatClock(*q.ActiveFrom), formatClock(*q.ActiveTo)))
+	}
+
+	if q.LocationFilter != nil {
+		b.WriteString(f("\nOrt enthält: <b>%s</b>", html.EscapeString(*q.LocationFilter)))
+	}
+
+	if q.SellerType != nil {
+		b.WriteString(f("\nAnbieter: <b>%s</b>", sellerTypeNames[*q.SellerType]))
+	}
+
 	return b.String()
 }
 
@@ -265,23 +624,59 @@ func formatQueryRaw(q model.Query) string {
 	var b strings.Builder
 	f := fmt.Sprintf
 
+	if q.Source != "" && q.Source != scraper.DefaultSource {
+		b.WriteString(f("Quelle: %s\n", q.Source))
+	}
+
 	if q.CustomLink != nil {
 		b.WriteString(f("Link: %s\n", *q.CustomLink))
 		b.WriteString(f("ID: %v", q.ID))
 	} else {
 		b.WriteString(f("Suchbegriff: %s\n", q.Term))
 		b.WriteString(f("Radius: %v km\n", q.Radius))
-		b.WriteString(f("Stadt: %s\n", q.CityName))
+		b.WriteString(f("Stadt: %s", q.CityName))
+	}
 
-		if q.MaxPrice != nil {
-			b.WriteString(f("Max Preis: %v €", q.MaxPrice))
-		}
+	if q.MaxPrice != nil {
+		b.WriteString(f("\nMax Preis: %v €", *q.MaxPrice))
+	}
 
-		if q.MinPrice != nil {
-			b.WriteString(f("Max Preis: %v €", q.MinPrice))
+	if q.MinPrice != nil {
+		b.WriteString(f("\nMin Preis: %v €", *q.MinPrice))
+	}
+
+	if includes := q.Includes(); len(includes) > 0 {
+		b.WriteString(f("\nMit: %s", strings.Join(includes, ", ")))
+	}
+
+	if excludes := q.Excludes(); len(excludes) > 0 {
+		b.WriteString(f("\nOhne: %s", strings.Join(excludes, ", ")))
+
+		if q.ExcludeInDescription {
+			b.WriteString(" (auch in der Beschreibung)")
 		}
 	}
 
+	if q.MatchExpression != nil {
+		b.WriteString(f("\nAusdruck: %s", *q.MatchExpression))
+	}
+
+	if q.Interval > 0 {
+		b.WriteString(f("\nIntervall: %d min", q.Interval))
+	}
+
+	if q.ActiveFrom != nil && q.ActiveTo != nil {
+		b.WriteString(f("\nAktiv: %s-%s", formatClock(*q.ActiveFrom), formatClock(*q.ActiveTo)))
+	}
+
+	if q.LocationFilter != nil {
+		b.WriteString(f("\nOrt enthält: %s", *q.LocationFilter))
+	}
+
+	if q.SellerType != nil {
+		b.WriteString(f("\nAnbieter: %s", sellerTypeNames[*q.SellerType]))
+	}
+
 	return b.String()
 }
 
@@ -307,57 +702,332 @@ func formatAdRaw(ad scraper.Ad, term string, id int) string {
 	return b.String()
 }
 
-func getQueryFromArgs(args string, chatID int64, s *storage.Storage) (*model.Query, bool) {
+func getQueryFromArgs(ctx context.Context, args string, chatID int64, s *alert.Service) (*model.Query, error) {
 	arr := strings.SplitN(args, ",", -1)
 
 	if len(arr) < 3 || len(arr) > 5 {
-		return nil, false
+		return nil, errUsage
 	}
 
-	term := arr[0]
+	term, exclude := splitExcludeTerms(arr[0])
+
+	if term == "" {
+		return nil, errUsage
+	}
 	city := arr[1]
 
 	radius, err := strconv.Atoi(strings.Trim(arr[2], " "))
 	if err != nil {
-		return nil, false
+		return nil, errUsage
 	}
 
 	var q *model.Query
 
 	if len(arr) > 3 {
-		price, err := strconv.Atoi(strings.Trim(arr[3], " "))
+		price, convErr := strconv.Atoi(strings.Trim(arr[3], " "))
 
-		if err != nil {
-			return nil, false
+		if convErr != nil {
+			return nil, errUsage
 		}
 
 		if len(arr) > 4 {
-			minPrice, err := strconv.Atoi(strings.Trim(arr[4], " "))
+			minPrice, convErr := strconv.Atoi(strings.Trim(arr[4], " "))
 
-			if err != nil {
-				return nil, false
+			if convErr != nil {
+				return nil, errUsage
 			}
 
-			q, err = s.AddNewQuery(term, city, radius, &price, &minPrice, chatID)
+			q, err = s.AddQuery(ctx, scraper.DefaultSource, term, exclude, city, radius, &price, &minPrice, chatID)
 		} else {
-			q, err = s.AddNewQuery(term, city, radius, &price, nil, chatID)
+			q, err = s.AddQuery(ctx, scraper.DefaultSource, term, exclude, city, radius, &price, nil, chatID)
 		}
 
 	} else {
-		q, err = s.AddNewQuery(term, city, radius, nil, nil, chatID)
+		q, err = s.AddQuery(ctx, scraper.DefaultSource, term, exclude, city, radius, nil, nil, chatID)
 	}
 
 	if err != nil {
 		log.Warn().Err(err).
-			Str("term", q.Term).
-			Str("city", q.CityName).
-			Int("radius", q.Radius).
+			Str("term", term).
+			Str("city", city).
+			Int("radius", radius).
 			Msg("could not create query")
 
-		return nil, false
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func getLinkQueryFromArgs(ctx context.Context, args string, chatID int64, s *alert.Service) (*model.Query, error) {
+	arr := strings.Split(args, ",")
+
+	if len(arr) > 3 {
+		return nil, errUsage
+	}
+
+	link := strings.Trim(arr[0], " ")
+	prices := make([]*int, 2, 2)
+
+	for i, arg := range arr[1:] {
+		price, err := strconv.Atoi(strings.Trim(arg, " "))
+
+		if err != nil {
+			return nil, errUsage
+		}
+
+		prices[i] = &price
+	}
+
+	return s.AddQueryViaLink(ctx, link, prices[0], prices[1], chatID)
+}
+
+// splitExcludeTerms splits words prefixed with "-" from the search term
+func splitExcludeTerms(arg string) (string, []string) {
+	words := make([]string, 0)
+	exclude := make([]string, 0)
+
+	for _, word := range strings.Fields(arg) {
+		if strings.HasPrefix(word, "-") {
+			if len(word) > 1 {
+				exclude = append(exclude, word[1:])
+			}
+			continue
+		}
+
+		words = append(words, word)
 	}
 
-	return q, true
+	return strings.Join(words, " "), exclude
+}
+
+var sellerTypeNames = map[string]string{
+	scraper.SellerPrivate:    "privat",
+	scraper.SellerCommercial: "gewerblich",
+}
+
+func setFilterFromArgs(ctx context.Context, args string, chatID int64, s storage.Store) string {
+	usage := "Um einen Filter zu setzen schreibe <code>/filter {ID}, {mit|ohne|beschreibung|ort|anbieter}, {Wert|aus}</code>. " +
+		"Mit sind Wörter, die alle im Titel vorkommen müssen. Ohne sind Wörter, die nicht im Titel vorkommen dürfen. Mit <code>beschreibung, an</code> werden sie auch in der Beschreibung gesucht. " +
+		"Anbieter kann <code>privat</code> oder <code>gewerblich</code> sein."
+	arr := strings.SplitN(args, ",", 3)
+
+	if len(arr) != 3 {
+		return usage
+	}
+
+	id, err := strconv.ParseUint(strings.Trim(arr[0], " "), 10, 0)
+
+	if err != nil {
+		return "Konnte ID nicht lesen. Diese sollte eine ganze positive Zahl sein."
+	}
+
+	q := s.FindQueryByID(ctx, uint(id))
+
+	if q == nil || q.ChatID != chatID {
+		return "Suche nicht gefunden."
+	}
+
+	value := strings.Trim(arr[2], " ")
+	var setting *string
+
+	if strings.ToLower(value) != "aus" && value != "" {
+		setting = &value
+	}
+
+	switch strings.ToLower(strings.Trim(arr[1], " ")) {
+	case "mit":
+		include := make([]string, 0)
+
+		if setting != nil {
+			include = strings.Fields(value)
+		}
+
+		q.SetIncludeTerms(include)
+	case "ohne":
+		exclude := make([]string, 0)
+
+		if setting != nil {
+			for _, word := range strings.Fields(value) {
+				if word = strings.TrimPrefix(word, "-"); word != "" {
+					exclude = append(exclude, word)
+				}
+			}
+		}
+
+		q.SetExcludeTerms(exclude)
+	case "beschreibung":
+		switch strings.ToLower(value) {
+		case "an":
+			q.ExcludeInDescription = true
+		case "aus":
+			q.ExcludeInDescription = false
+		default:
+			return usage
+		}
+	case "ort":
+		q.LocationFilter = setting
+	case "anbieter":
+		if setting != nil {
+			sellerType := ""
+
+			for key, name := range sellerTypeNames {
+				if name == strings.ToLower(value) {
+					sellerType = key
+				}
+			}
+
+			if sellerType == "" {
+				return usage
+			}
+
+			setting = &sellerType
+		}
+
+		q.SellerType = setting
+	default:
+		return usage
+	}
+
+	if s.SaveQuery(ctx, q) != nil {
+		return "Filter konnte nicht gespeichert werden."
+	}
+
+	return "Filter gespeichert.\n\n" + formatQuery(*q)
+}
+
+func setMatchFromArgs(ctx context.Context, args string, chatID int64, s storage.Store) string {
+	arr := strings.SplitN(args, ",", 2)
+
+	if len(arr) != 2 {
+		return "Um einen Ausdruck zu setzen schreibe <code>/match {ID}, {Ausdruck|aus}</code>, z.B. <code>/match 12, (rtx 3080 OR rtx 3090) AND NOT defekt</code>. " +
+			"Erlaubt sind Wörter, \"Phrasen\", /Regex/ oder re:Regex, Klammern und AND, OR, NOT in beliebiger Schreibweise."
+	}
+
+	id, err := strconv.ParseUint(strings.Trim(arr[0], " "), 10, 0)
+
+	if err != nil {
+		return "Konnte ID nicht lesen. Diese sollte eine ganze positive Zahl sein."
+	}
+
+	q := s.FindQueryByID(ctx, uint(id))
+
+	if q == nil || q.ChatID != chatID {
+		return "Suche nicht gefunden."
+	}
+
+	expression := strings.Trim(arr[1], " ")
+
+	if strings.ToLower(expression) == "aus" || expression == "" {
+		q.MatchExpression = nil
+	} else {
+		if _, err := match.Parse(expression); err != nil {
+			return fmt.Sprintf("Der Ausdruck ist ungültig: %s", html.EscapeString(err.Error()))
+		}
+
+		q.MatchExpression = &expression
+	}
+
+	if s.SaveQuery(ctx, q) != nil {
+		return "Ausdruck konnte nicht gespeichert werden."
+	}
+
+	return "Ausdruck gespeichert.\n\n" + formatQuery(*q)
+}
+
+func setIntervalFromArgs(ctx context.Context, args string, chatID int64, s storage.Store) string {
+	usage := "Um das Intervall zu setzen schreibe <code>/interval {ID}, {Minuten}, {Aktive Zeit wie 07:00-23:00 oder immer}?</code>"
+	arr := strings.Split(args, ",")
+
+	if len(arr) < 2 || len(arr) > 3 {
+		return usage
+	}
+
+	id, err := strconv.ParseUint(strings.Trim(arr[0], " "), 10, 0)
+
+	if err != nil {
+		return "Konnte ID nicht lesen. Diese sollte eine ganze positive Zahl sein."
+	}
+
+	interval, err := strconv.Atoi(strings.Trim(arr[1], " "))
+
+	if err != nil || interval < 1 || interval > maxInterval {
+		return fmt.Sprintf("Das Intervall muss eine ganze Zahl zwischen 1 und %d Minuten sein.", maxInterval)
+	}
+
+	q := s.FindQueryByID(ctx, uint(id))
+
+	if q == nil || q.ChatID != chatID {
+		return "Suche nicht gefunden."
+	}
+
+	q.Interval = interval
+
+	if len(arr) == 3 {
+		hours := strings.Trim(arr[2], " ")
+
+		if strings.ToLower(hours) == "immer" {
+			q.ActiveFrom = nil
+			q.ActiveTo = nil
+		} else {
+			bounds := strings.Split(hours, "-")
+
+			if len(bounds) != 2 {
+				return usage
+			}
+
+			from, errFrom := parseClock(bounds[0])
+			to, errTo := parseClock(bounds[1])
+
+			if errFrom != nil || errTo != nil || from == to {
+				return usage
+			}
+
+			q.ActiveFrom = &from
+			q.ActiveTo = &to
+		}
+	}
+
+	if s.SaveQuery(ctx, q) != nil {
+		return "Intervall konnte nicht gespeichert werden."
+	}
+
+	return "Intervall gespeichert.\n\n" + formatQuery(*q)
+}
+
+// parseClock parses a time like 07:30 to the minutes since midnight. 24:00 is allowed as end of the day
+func parseClock(clock string) (int, error) {
+	parts := strings.Split(strings.Trim(clock, " "), ":")
+
+	if len(parts) > 2 {
+		return 0, errors.New("invalid time")
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+
+	if err != nil {
+		return 0, err
+	}
+
+	minutes := 0
+
+	if len(parts) == 2 {
+		minutes, err = strconv.Atoi(parts[1])
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, errors.New("invalid time")
+	}
+
+	return hours*60 + minutes, nil
+}
+
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
 }
 
 func generateHelpText() string {
@@ -366,13 +1036,39 @@ func generateHelpText() string {
 	b.WriteString(f("<u>Hinzufügen von Suchen</u>\n"))
 	b.WriteString(f("schreibe <code>/add {Suchbegriff}, {Stadt/PLZ}, {Radius}, {Max Preis ohne \"€\", \",\",\".\"}?, {Min Preis ohne \"€\", \",\",\".\"}?</code>\n"))
 	b.WriteString(f("z.B. <code>/add Fahrrad, Köln, 20</code>\n"))
-	b.WriteString(f("Dies führt jede minute eine Suche aus und du kommst die neuesten Einträge hier.\n"))
+	b.WriteString(f("Wörter mit einem \"-\" davor werden ausgeschlossen, z.B. <code>/add iphone -hülle -case, Köln, 20</code>\n"))
+	b.WriteString(f("Dies führt regelmäßig eine Suche aus und du bekommst die neuesten Einträge hier. Suchen mit vielen neuen Anzeigen werden häufiger ausgeführt.\n"))
 
 	b.WriteString(f("\n"))
 	b.WriteString(f("<u>Listen von alles Suchen</u>\n"))
 	b.WriteString(f("schreibe <code>/list</code>\n"))
 	b.WriteString(f("Dies listet alle deine aktuellen Suchen\n"))
 
+	b.WriteString(f("\n"))
+	b.WriteString(f("<u>Hinzufügen von Suchen via Link</u>\n"))
+	b.WriteString(f("schreibe <code>/link {Link}, {Max Preis}?, {Min Preis}?</code>\n"))
+	b.WriteString(f("Der Link muss eine Suche auf kleinanzeigen.de sein.\n"))
+
+	b.WriteString(f("\n"))
+	b.WriteString(f("<u>Filtern von Suchen</u>\n"))
+	b.WriteString(f("schreibe <code>/filter {ID}, {mit|ohne|beschreibung|ort|anbieter}, {Wert|aus}</code>\n"))
+	b.WriteString(f("z.B. <code>/filter 12, mit, 256gb</code>, <code>/filter 12, ohne, hülle case</code>, <code>/filter 12, anbieter, privat</code> oder <code>/filter 12, ort, Köln</code>\n"))
+
+	b.WriteString(f("\n"))
+	b.WriteString(f("<u>Ausdrücke für den Titel</u>\n"))
+	b.WriteString(f("schreibe <code>/match {ID}, {Ausdruck|aus}</code>\n"))
+	b.WriteString(f("z.B. <code>/match 12, (rtx 3080 OR rtx 3090) AND NOT defekt</code>\n"))
+
+	b.WriteString(f("\n"))
+	b.WriteString(f("<u>Intervall und aktive Zeit</u>\n"))
+	b.WriteString(f("schreibe <code>/interval {ID}, {Minuten}, {Aktive Zeit}?</code>\n"))
+	b.WriteString(f("z.B. <code>/interval 12, 30, 07:00-23:00</code> sucht alle 30 Minuten zwischen 7 und 23 Uhr. <code>immer</code> entfernt die aktive Zeit.\n"))
+
+	b.WriteString(f("\n"))
+	b.WriteString(f("<u>Status von Suchen</u>\n"))
+	b.WriteString(f("schreibe <code>/status</code>\n"))
+	b.WriteString(f("Dies zeigt Fehler deiner Suchen. Suchen, die %d mal in Folge fehlschlagen, werden pausiert und können mit <code>/retry {ID}</code> fortgesetzt werden.\n", model.QuarantineAfter))
+
 	b.WriteString(f("\n"))
 	b.WriteString(f("<u>Entfernen von Suchen</u>\n"))
 	b.WriteString(f("schreibe <code>/remove {ID}</code>\n"))
@@ -0,0 +1,67 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/model"
+)
+
+// markup are the tags formatQuery adds itself
+var markup = strings.NewReplacer("<b>", "", "</b>", "", "<code>", "", "</code>", "")
+
+func TestFormatQueryEscapesUserInput(t *testing.T) {
+	evil := "<i>x</i> & <a href=\"y\">"
+	expression := "a AND <b>"
+	location := "<köln>"
+
+	queries := map[string]model.Query{
+		"search": {Source: "<quelle>", Term: evil, CityName: evil, MatchExpression: &expression, LocationFilter: &location},
+		"link":   {CustomLink: &evil},
+	}
+
+	for name, q := range queries {
+		q.SetIncludeTerms([]string{"<mit>"})
+		q.SetExcludeTerms([]string{"<ohne>", "&"})
+
+		t.Run(name, func(t *testing.T) {
+			text := formatQuery(q)
+
+			if strings.ContainsAny(markup.Replace(text), "<>") {
+				t.Errorf("user input is not escaped:\n%s", text)
+			}
+
+			if !strings.Contains(text, "&lt;mit&gt;") || !strings.Contains(text, "&lt;ohne&gt;, &amp;") {
+				t.Errorf("filter terms are missing:\n%s", text)
+			}
+		})
+	}
+}
+
+func TestFormatQueryRaw(t *testing.T) {
+	link := "https://www.kleinanzeigen.de/s-fahrrad/k0?a=1&b=2"
+	max := 200
+	min := 50
+
+	search := model.Query{Term: "fahrrad", Radius: 10, CityName: "Köln", MaxPrice: &max, MinPrice: &min}
+	want := "Suchbegriff: fahrrad\nRadius: 10 km\nStadt: Köln\nMax Preis: 200 €\nMin Preis: 50 €"
+
+	if text := formatQueryRaw(search); text != want {
+		t.Errorf("search query = %q, want %q", text, want)
+	}
+
+	// the raw text is sent without parse mode, so the link is not escaped
+	linked := model.Query{CustomLink: &link, MaxPrice: &max}
+	linked.ID = 3
+	want = "Link: " + link + "\nID: 3\nMax Preis: 200 €"
+
+	if text := formatQueryRaw(linked); text != want {
+		t.Errorf("link query = %q, want %q", text, want)
+	}
+}
+
+func TestHelpTextListsFilters(t *testing.T) {
+	if help := generateHelpText(); !strings.Contains(help, "/filter {ID}, {mit|ohne|beschreibung|ort|anbieter}") {
+		t.Errorf("help does not list every filter:\n%s", help)
+	}
+}
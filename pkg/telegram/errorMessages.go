@@ -0,0 +1,49 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/danielstefank/kleinanzeigen-alert/pkg/scraper"
+)
+
+// errUsage is returned if the arguments of a command could not be read
+var errUsage = errors.New("invalid arguments")
+
+// the number of cities listed if the city is ambiguous
+const maxCityCandidates = 8
+
+// ErrorText explains an error of the scraper or storage to the user. The text is html escaped
+func ErrorText(err error) string {
+	var cityErr *scraper.CityError
+
+	switch {
+	case errors.As(err, &cityErr) && errors.Is(err, scraper.ErrCityAmbiguous):
+		candidates := cityErr.Candidates
+
+		if len(candidates) > maxCityCandidates {
+			candidates = candidates[:maxCityCandidates]
+		}
+
+		return fmt.Sprintf("Der Ort <b>%s</b> ist nicht eindeutig. Meintest du: %s? Gib den Ort genauer oder als PLZ an.",
+			html.EscapeString(cityErr.City), html.EscapeString(strings.Join(candidates, ", ")))
+	case errors.As(err, &cityErr):
+		return fmt.Sprintf("Der Ort <b>%s</b> wurde nicht gefunden.", html.EscapeString(cityErr.City))
+	case errors.Is(err, scraper.ErrInvalidLink):
+		return "Der Link wird nicht unterstützt. Kopiere den Link einer Suche auf Kleinanzeigen."
+	case errors.Is(err, scraper.ErrBlocked):
+		return "Kleinanzeigen blockiert den Bot gerade. Versuche es später erneut."
+	case errors.Is(err, scraper.ErrRateLimited):
+		return "Kleinanzeigen hat zu viele Anfragen erhalten. Versuche es in ein paar Minuten erneut."
+	case errors.Is(err, scraper.ErrTimeout):
+		return "Kleinanzeigen hat nicht rechtzeitig geantwortet. Versuche es später erneut."
+	case errors.Is(err, scraper.ErrNotFound):
+		return "Die Seite wurde auf Kleinanzeigen nicht gefunden. Prüfe den Link."
+	case errors.Is(err, scraper.ErrLayoutChanged), errors.Is(err, scraper.ErrParse):
+		return "Die Seite von Kleinanzeigen konnte nicht gelesen werden. Vermutlich hat sich die Seite geändert."
+	}
+
+	return "Es ist ein unbekannter Fehler aufgetreten. Versuche es später erneut."
+}